@@ -0,0 +1,182 @@
+package dnstrie
+
+import (
+	"runtime"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// WithWorkers sets the number of goroutines MatchBatch and MatchStream spread
+// the names over and BuildFromReader builds subtries on. By default, or if
+// workers is less than 1, they use runtime.GOMAXPROCS(0).
+func WithWorkers(workers int) Option {
+	return func(o *options) {
+		o.workers = workers
+	}
+}
+
+// workerCount returns the number of goroutines to use.
+func (o *options) workerCount() int {
+	if o.workers > 0 {
+		return o.workers
+	}
+	return runtime.GOMAXPROCS(0)
+}
+
+// MatchResult is a name read by MatchStream and if it matched.
+type MatchResult struct {
+	Domain  string
+	Matched bool
+}
+
+// batchSize is the number of names a worker takes at a time. It is large
+// enough to make handing out work cheap compared to matching.
+const batchSize = 256
+
+// MatchBatch sets out[i] to Match(domains[i]) for every name, matching on
+// several goroutines, see WithWorkers. out must be at least as long as
+// domains.
+func (t *Trie[V]) MatchBatch(domains []string, out []bool) {
+	matchBatch(t.Match, t.opts.workerCount(), domains, out)
+}
+
+// MatchStream matches every name received from domains on several goroutines,
+// see WithWorkers, and sends the results in the order the names were
+// received. The returned channel is closed once domains is closed and every
+// result was sent. The caller must read every result: the goroutines of
+// MatchStream only exit once the returned channel is closed, so a caller that
+// stops reading early leaks them.
+func (t *Trie[V]) MatchStream(domains <-chan string) <-chan MatchResult {
+	return matchStream(t.Match, t.opts.workerCount(), domains)
+}
+
+// MatchBatch is like DomainTrie.MatchBatch.
+func (c *CompiledTrie) MatchBatch(domains []string, out []bool) {
+	matchBatch(c.Match, c.opts.workerCount(), domains, out)
+}
+
+// MatchStream is like DomainTrie.MatchStream.
+func (c *CompiledTrie) MatchStream(domains <-chan string) <-chan MatchResult {
+	return matchStream(c.Match, c.opts.workerCount(), domains)
+}
+
+// MatchBatch is like DomainTrie.MatchBatch on the current version of the
+// trie.
+func (s *SyncTrie) MatchBatch(domains []string, out []bool) {
+	s.Load().MatchBatch(domains, out)
+}
+
+// MatchStream is like DomainTrie.MatchStream on the version of the trie that
+// is current when it is called.
+func (s *SyncTrie) MatchStream(domains <-chan string) <-chan MatchResult {
+	return s.Load().MatchStream(domains)
+}
+
+// matchBatch implements MatchBatch for match. Workers take batches of
+// consecutive names until none are left.
+func matchBatch(match func(string) bool, workers int, domains []string, out []bool) {
+	out = out[:len(domains)]
+	if batches := (len(domains) + batchSize - 1) / batchSize; workers > batches {
+		workers = batches
+	}
+	if workers <= 1 {
+		for i, domain := range domains {
+			out[i] = match(domain)
+		}
+		return
+	}
+	var next atomic.Int64
+	var wg sync.WaitGroup
+	wg.Add(workers)
+	for w := 0; w < workers; w++ {
+		go func() {
+			defer wg.Done()
+			for {
+				end := int(next.Add(batchSize))
+				start := end - batchSize
+				if start >= len(domains) {
+					return
+				}
+				if end > len(domains) {
+					end = len(domains)
+				}
+				for i := start; i < end; i++ {
+					out[i] = match(domains[i])
+				}
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+// streamBatch is a batch of names read by MatchStream. done is closed once
+// results is filled in.
+type streamBatch struct {
+	results []MatchResult
+	done    chan struct{}
+}
+
+// flushDelay is how long MatchStream waits for a batch to fill before matching
+// the names it has, so the names of a slow producer are not held back.
+const flushDelay = time.Millisecond
+
+// readBatch returns a batch starting with domain followed by the names
+// received from domains until it holds batchSize names, domains is closed or
+// flushDelay has passed.
+func readBatch(domain string, domains <-chan string) *streamBatch {
+	b := &streamBatch{results: make([]MatchResult, 1, batchSize), done: make(chan struct{})}
+	b.results[0].Domain = domain
+	timer := time.NewTimer(flushDelay)
+	defer timer.Stop()
+	for len(b.results) < batchSize {
+		select {
+		case domain, ok := <-domains:
+			if !ok {
+				return b
+			}
+			b.results = append(b.results, MatchResult{Domain: domain})
+		case <-timer.C:
+			return b
+		}
+	}
+	return b
+}
+
+// matchStream implements MatchStream for match. Names are read in batches,
+// see readBatch, which are matched by the workers and queued in order for
+// the sender.
+func matchStream(match func(string) bool, workers int, domains <-chan string) <-chan MatchResult {
+	out := make(chan MatchResult, batchSize)
+	jobs := make(chan *streamBatch, workers)
+	ordered := make(chan *streamBatch, 2*workers)
+	for w := 0; w < workers; w++ {
+		go func() {
+			for b := range jobs {
+				for i := range b.results {
+					b.results[i].Matched = match(b.results[i].Domain)
+				}
+				close(b.done)
+			}
+		}()
+	}
+	go func() {
+		defer close(ordered)
+		defer close(jobs)
+		for domain := range domains {
+			b := readBatch(domain, domains)
+			ordered <- b
+			jobs <- b
+		}
+	}()
+	go func() {
+		defer close(out)
+		for b := range ordered {
+			<-b.done
+			for _, r := range b.results {
+				out <- r
+			}
+		}
+	}()
+	return out
+}
@@ -0,0 +1,108 @@
+package dnstrie
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+// batchDomains returns n names of which every third matches batchRules.
+func batchDomains(n int) []string {
+	domains := make([]string, n)
+	for i := range domains {
+		switch i % 3 {
+		case 0:
+			domains[i] = fmt.Sprintf("host%d.ads.com", i)
+		case 1:
+			domains[i] = fmt.Sprintf("safe%d.example.com", i)
+		default:
+			domains[i] = fmt.Sprintf("host%d.example.org", i)
+		}
+	}
+	return domains
+}
+
+var batchRules = []string{"+.ads.com", "example.com"}
+
+func TestMatchBatch(t *testing.T) {
+	for _, workers := range []int{0, 1, 3, 16} {
+		trie, err := MakeTrie(batchRules, WithWorkers(workers))
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, n := range []int{0, 1, batchSize - 1, 10*batchSize + 7} {
+			domains := batchDomains(n)
+			out := make([]bool, n)
+			trie.MatchBatch(domains, out)
+			compiled := make([]bool, n)
+			trie.Freeze().MatchBatch(domains, compiled)
+			for i, domain := range domains {
+				if out[i] != trie.Match(domain) || compiled[i] != out[i] {
+					t.Fatalf("MatchBatch with %d workers got %v for %v", workers, out[i], domain)
+				}
+			}
+		}
+	}
+}
+
+func TestMatchStream(t *testing.T) {
+	for _, workers := range []int{1, 4} {
+		trie, err := MakeTrie(batchRules, WithWorkers(workers))
+		if err != nil {
+			t.Fatal(err)
+		}
+		domains := batchDomains(5*batchSize + 3)
+		in := make(chan string)
+		go func() {
+			for _, domain := range domains {
+				in <- domain
+			}
+			close(in)
+		}()
+		i := 0
+		for r := range NewSyncTrie(trie).MatchStream(in) {
+			if r.Domain != domains[i] || r.Matched != trie.Match(r.Domain) {
+				t.Fatalf("MatchStream with %d workers got %+v at %d, expected %v", workers, r, i, domains[i])
+			}
+			i++
+		}
+		if i != len(domains) {
+			t.Fatalf("MatchStream with %d workers got %d results expected %d", workers, i, len(domains))
+		}
+	}
+}
+
+// TestReadBatch checks that names sent one at a time are still matched in
+// full batches and that a slow producer is not held back.
+func TestReadBatch(t *testing.T) {
+	in := make(chan string)
+	go func() {
+		for _, domain := range batchDomains(2*batchSize + 1) {
+			in <- domain
+		}
+		close(in)
+	}()
+	var sizes []int
+	for domain := range in {
+		sizes = append(sizes, len(readBatch(domain, in).results))
+	}
+	if len(sizes) != 3 || sizes[0] != batchSize || sizes[1] != batchSize || sizes[2] != 1 {
+		t.Errorf("readBatch got batches of %v", sizes)
+	}
+
+	slow := make(chan string)
+	start := time.Now()
+	if b := readBatch("example.com", slow); len(b.results) != 1 || time.Since(start) < flushDelay {
+		t.Errorf("readBatch did not flush a partial batch after flushDelay")
+	}
+}
+
+func BenchmarkMatchBatch(b *testing.B) {
+	trie, _ := MakeTrie(batchRules)
+	domains := batchDomains(100000)
+	out := make([]bool, len(domains))
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		trie.MatchBatch(domains, out)
+	}
+}
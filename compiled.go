@@ -0,0 +1,270 @@
+package dnstrie
+
+import (
+	"sort"
+)
+
+// CompiledTrie is an immutable, compact form of a DomainTrie with the same
+// Match semantics. Create one with `DomainTrie.Freeze`.
+//
+// Nodes are numbered in breadth-first order, so the children of every node are
+// contiguous, with patterns and depth-bounded wildcards first in the order they
+// are tried and the rest sorted by label. A node is described by a handful of
+// array entries instead of a heap object: the id of its label, the index of its
+// first child, its rule kinds and five bits: if it is an ending state, if it is
+// excluded by an exception, the same two for the names below it as decided by
+// its "+" child, and if it is listed in the patterns of its parent. Every
+// distinct label is stored once. Children are found through a single open
+// addressing hash table keyed by parent and label, so lookups stay constant
+// time for nodes with a large fan-out.
+type CompiledTrie struct {
+	// labels holds the text of every distinct label back to back and
+	// labelOffsets[i]:labelOffsets[i+1] is the text of label i.
+	labels       []byte
+	labelOffsets []uint32
+	// nodeLabels[n] is the label id of node n and the children of node n
+	// are the nodes firstChild[n]:firstChild[n+1].
+	nodeLabels     []uint32
+	firstChild     []uint32
+	rules          []uint8
+	end            bitset
+	except         bitset
+	wildcard       bitset
+	wildcardExcept bitset
+	pattern        bitset
+	// exceptions is true if the trie has exception rules. Without any,
+	// matching can stop at the first wildcard.
+	exceptions bool
+	opts       options
+	// filter is a copy of the filter of the DomainTrie, see WithPrefilter.
+	filter *prefilter
+	// index is a power of two sized hash table of node id + 1 (0 for an
+	// empty slot) for every node except the root.
+	index []uint32
+}
+
+type bitset []uint64
+
+func newBitset(n int) bitset {
+	return make(bitset, (n+63)/64)
+}
+
+func (b bitset) set(i uint32) {
+	b[i/64] |= 1 << (i % 64)
+}
+
+func (b bitset) has(i uint32) bool {
+	return b[i/64]&(1<<(i%64)) != 0
+}
+
+// Freeze returns a CompiledTrie with the same rules as root. root can still be
+// modified afterwards without affecting the CompiledTrie.
+func (root *DomainTrie) Freeze() *CompiledTrie {
+	c := &CompiledTrie{labelOffsets: []uint32{0}, exceptions: root.exceptions > 0, opts: root.opts, filter: root.filter.clone()}
+	interned := make(map[string]uint32)
+	intern := func(label string) uint32 {
+		id, ok := interned[label]
+		if !ok {
+			id = uint32(len(interned))
+			interned[label] = id
+			c.labels = append(c.labels, label...)
+			c.labelOffsets = append(c.labelOffsets, uint32(len(c.labels)))
+		}
+		return id
+	}
+
+	queue := []*node[struct{}]{&root.root}
+	for i := 0; i < len(queue); i++ {
+		n := queue[i]
+		c.nodeLabels = append(c.nodeLabels, intern(n.label))
+		c.firstChild = append(c.firstChild, uint32(len(queue)))
+		c.rules = append(c.rules, n.rules)
+		queue = append(queue, n.orderedChildren()...)
+	}
+	c.firstChild = append(c.firstChild, uint32(len(queue)))
+
+	c.buildIndex()
+	c.end = newBitset(len(queue))
+	c.except = newBitset(len(queue))
+	c.wildcard = newBitset(len(queue))
+	c.wildcardExcept = newBitset(len(queue))
+	c.pattern = newBitset(len(queue))
+	for i, n := range queue {
+		if i > 0 && isPatternChild(n.label) {
+			c.pattern.set(uint32(i))
+		}
+		if n.end {
+			c.end.set(uint32(i))
+		}
+		if n.except {
+			c.except.set(uint32(i))
+		}
+		if wildcard := findNode("+", n.others); wildcard != nil {
+			if wildcard.end {
+				c.wildcard.set(uint32(i))
+			}
+			if wildcard.except {
+				c.wildcardExcept.set(uint32(i))
+			}
+		}
+	}
+	return c
+}
+
+// buildIndex builds the child lookup table, sized to be at most two thirds
+// full.
+func (c *CompiledTrie) buildIndex() {
+	size := 1
+	for size < len(c.nodeLabels)*3/2 {
+		size *= 2
+	}
+	c.index = make([]uint32, size)
+	mask := uint32(size - 1)
+	for parent := uint32(0); int(parent) < len(c.nodeLabels); parent++ {
+		for child := c.firstChild[parent]; child < c.firstChild[parent+1]; child++ {
+			h := childHash(parent, c.label(child))
+			for c.index[h&mask] != 0 {
+				h++
+			}
+			c.index[h&mask] = child + 1
+		}
+	}
+}
+
+// childHash is FNV-1a over the label, seeded with the parent's id. It must be
+// stable across processes since it determines the layout of the index.
+func childHash[S string | []byte](parent uint32, label S) uint32 {
+	h := uint32(2166136261) ^ parent*16777619
+	for i := 0; i < len(label); i++ {
+		h ^= uint32(label[i])
+		h *= 16777619
+	}
+	return h
+}
+
+func (c *CompiledTrie) label(n uint32) []byte {
+	id := c.nodeLabels[n]
+	return c.labels[c.labelOffsets[id]:c.labelOffsets[id+1]]
+}
+
+// orderedChildren returns the children of n, starting with its patterns in
+// the order they are tried followed by the other children sorted by label,
+// with chains expanded.
+func (n *node[V]) orderedChildren() []*node[V] {
+	children := make([]*node[V], 0, len(n.others))
+	for _, pattern := range n.patterns {
+		children = append(children, n.others[pattern])
+	}
+	literals := len(children)
+	for _, child := range n.others {
+		if !isPatternChild(child.label) {
+			children = append(children, child.expand())
+		}
+	}
+	sort.Slice(children[literals:], func(i, j int) bool {
+		return children[literals+i].label < children[literals+j].label
+	})
+	return children
+}
+
+// Empty returns true if the trie has no rules and false otherwise.
+func (c *CompiledTrie) Empty() bool {
+	return len(c.nodeLabels) == 0 || (c.firstChild[0] == c.firstChild[1] && !c.end.has(0))
+}
+
+// Match is like DomainTrie.Match. It does not allocate.
+func (c *CompiledTrie) Match(domain string) bool {
+	return compiledMatch(c, c.opts.name(domain))
+}
+
+// MatchBytes is like DomainTrie.MatchBytes. It does not allocate.
+func (c *CompiledTrie) MatchBytes(domain []byte) bool {
+	if c.opts.normalize && !normalized(domain) {
+		return c.Match(string(domain))
+	}
+	if c.opts.trailingDot {
+		domain = trimTrailingDot(domain)
+	}
+	return compiledMatch(c, domain)
+}
+
+func compiledMatch[S string | []byte](c *CompiledTrie, domain S) bool {
+	if len(c.nodeLabels) == 0 || c.filter != nil && !mayMatch(c.filter, domain) {
+		return false
+	}
+	matched, _ := compiledMatchNode(c, 0, domain, len(domain))
+	return matched
+}
+
+// compiledMatchNode is like matchNode for node n.
+func compiledMatchNode[S string | []byte](c *CompiledTrie, n uint32, domain S, end int) (matched, ok bool) {
+	if end < 0 {
+		return c.end.has(n), c.end.has(n) || c.except.has(n)
+	}
+	if c.wildcard.has(n) && !c.exceptions {
+		return true, true
+	}
+	start := lastLabel(domain, end)
+	label := domain[start:end]
+	child, found := compiledChild(c, n, label)
+	if found {
+		if matched, ok := compiledMatchNode(c, child, domain, start-1); ok {
+			return matched, true
+		}
+	}
+	for p := c.firstChild[n]; p < c.firstChild[n+1] && c.pattern.has(p); p++ {
+		if pattern := c.label(p); (!found || p != child) && matchLabel(string(pattern), label) {
+			if matched, ok := compiledMatchNode(c, p, domain, start-1); ok {
+				return matched, true
+			}
+		}
+	}
+	return compiledDecidingWildcard(c, n, domain, end)
+}
+
+// compiledDecidingWildcard is like decidingWildcard for node n and returns if
+// the deciding wildcard matches and false for ok if there is none.
+func compiledDecidingWildcard[S string | []byte](c *CompiledTrie, n uint32, domain S, end int) (matched, ok bool) {
+	matched, ok = c.wildcard.has(n), c.wildcard.has(n) || c.wildcardExcept.has(n)
+	best := wildcardSpan{1, maxLabels, c.wildcardExcept.has(n)}
+	labels := -1
+	for p := c.firstChild[n]; p < c.firstChild[n+1] && c.pattern.has(p); p++ {
+		min, max, bounded := bounds(c.label(p))
+		if !bounded || !c.end.has(p) && !c.except.has(p) {
+			continue
+		}
+		if labels < 0 {
+			labels = countLabels(domain, end)
+		}
+		if span := (wildcardSpan{min, max, c.except.has(p)}); span.covers(labels) && (!ok || span.narrower(best)) {
+			matched, ok, best = c.end.has(p), true, span
+		}
+	}
+	return matched, ok
+}
+
+// compiledChild returns the child of parent with the given label.
+func compiledChild[S string | []byte](c *CompiledTrie, parent uint32, label S) (uint32, bool) {
+	mask := uint32(len(c.index) - 1)
+	first, last := c.firstChild[parent], c.firstChild[parent+1]
+	// Probing is bounded by the size of the index in case it is full,
+	// which only happens for unverified data.
+	h := childHash(parent, label)
+	for probes := 0; probes < len(c.index); probes++ {
+		slot := c.index[h&mask]
+		if slot == 0 {
+			return 0, false
+		}
+		if n := slot - 1; n >= first && n < last && string(c.label(n)) == string(label) {
+			return n, true
+		}
+		h++
+	}
+	return 0, false
+}
+
+// Size returns the approximate number of bytes used by the trie.
+func (c *CompiledTrie) Size() int {
+	return len(c.labels) + 4*(len(c.labelOffsets)+len(c.nodeLabels)+len(c.firstChild)) +
+		len(c.rules) + 8*(len(c.end)+len(c.except)+len(c.wildcard)+len(c.wildcardExcept)+len(c.pattern)) + 4*len(c.index)
+}
@@ -0,0 +1,118 @@
+package dnstrie
+
+import (
+	"runtime"
+	"strings"
+	"testing"
+)
+
+func TestFreeze(t *testing.T) {
+	rules := []string{"+.google.com", "www.google.org", "+.biz", "onizuka.homelinux.org", "*.yahoo.com", "a.b.c.d", "x.+.weird.net", "api.*.corp.example.com", "x.api.internal.corp.example.com"}
+	root, err := MakeTrie(rules)
+	if err != nil {
+		t.Fatalf("Failed to MakeTrie: %v", err)
+	}
+	compiled := root.Freeze()
+
+	domains := []string{
+		"www.google.org", "www.google.com", "google.com", "google.biz", "foo.google.biz",
+		"bar.foo.google.biz", "notarealdomain", "*.biz", "onizuka.homelinux.org",
+		"homelinux.org", "www.yahoo.com", "yahoo.com", "lots.of.children.yahoo.com",
+		"a.b.c.d", "b.c.d", "x.a.b.c.d", "", ".", "com", "x.+.weird.net", "+.weird.net", "a.weird.net",
+		"api.internal.corp.example.com", "api.x.corp.example.com", "api.corp.example.com",
+	}
+	domains = append(domains, benchmarkRules(100)...)
+	for _, d := range domains {
+		expected := root.Match(d)
+		if actual := compiled.Match(d); actual != expected {
+			t.Fatalf("Match(%q) got %v expected %v", d, actual, expected)
+		}
+		if actual := compiled.MatchBytes([]byte(d)); actual != expected {
+			t.Fatalf("MatchBytes(%q) got %v expected %v", d, actual, expected)
+		}
+	}
+
+	// The compiled trie is a copy.
+	root.Insert("+.org")
+	if compiled.Match("eff.org") {
+		t.Fatalf("Freeze shares state with the DomainTrie")
+	}
+}
+
+func TestFreezeEmpty(t *testing.T) {
+	root, _ := MakeTrie([]string{})
+	if compiled := root.Freeze(); !compiled.Empty() || compiled.Match("") {
+		t.Fatalf("Empty() failed for compiled empty trie: %+v", compiled)
+	}
+	if compiled := (&CompiledTrie{}); !compiled.Empty() || compiled.Match("google.com") {
+		t.Fatalf("Empty() failed for zero CompiledTrie")
+	}
+	root, _ = MakeTrie([]string{"google.com"})
+	if compiled := root.Freeze(); compiled.Empty() {
+		t.Fatalf("Empty() failed for compiled trie: %+v", compiled)
+	}
+}
+
+func TestCompiledMatchDoesNotAllocate(t *testing.T) {
+	root, _ := MakeTrie([]string{"+.google.com", "www.google.org", "mail.yahoo.com"})
+	compiled := root.Freeze()
+	buf := []byte("a.deep.sub.domain.of.mail.yahoo.com")
+	allocs := testing.AllocsPerRun(100, func() {
+		compiled.Match("www.google.com")
+		compiled.Match("www.google.org")
+		compiled.Match("not.in.the.trie")
+		compiled.MatchBytes(buf)
+	})
+	if allocs != 0 {
+		t.Fatalf("Match allocated %v times per run, expected 0", allocs)
+	}
+}
+
+func benchmarkCompiledMatch(b *testing.B, n int) {
+	rules := benchmarkRules(n)
+	root, err := MakeTrie(rules)
+	if err != nil {
+		b.Fatal(err)
+	}
+	compiled := root.Freeze()
+	queries := make([]string, 1024)
+	for i := range queries {
+		if i%2 == 0 {
+			queries[i] = "foo." + strings.TrimPrefix(strings.TrimPrefix(rules[(i*7919)%n], "+."), "*.")
+		} else {
+			queries[i] = "miss" + strings.Repeat("x", i%7) + ".com"
+		}
+	}
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		compiled.Match(queries[i%len(queries)])
+	}
+}
+
+func BenchmarkCompiledMatch1k(b *testing.B)   { benchmarkCompiledMatch(b, 1000) }
+func BenchmarkCompiledMatch10k(b *testing.B)  { benchmarkCompiledMatch(b, 10000) }
+func BenchmarkCompiledMatch100k(b *testing.B) { benchmarkCompiledMatch(b, 100000) }
+
+func heapInUse() uint64 {
+	var stats runtime.MemStats
+	runtime.GC()
+	runtime.ReadMemStats(&stats)
+	return stats.HeapAlloc
+}
+
+// BenchmarkTrieMemory reports the heap used per rule by a DomainTrie and by
+// its CompiledTrie.
+func BenchmarkTrieMemory(b *testing.B) {
+	rules := benchmarkRules(100000)
+	for i := 0; i < b.N; i++ {
+		before := heapInUse()
+		root, _ := MakeTrie(rules)
+		afterTrie := heapInUse()
+		compiled := root.Freeze()
+		afterCompiled := heapInUse()
+		b.ReportMetric(float64(afterTrie-before)/float64(len(rules)), "trie-B/rule")
+		b.ReportMetric(float64(afterCompiled-afterTrie)/float64(len(rules)), "compiled-B/rule")
+		runtime.KeepAlive(root)
+		runtime.KeepAlive(compiled)
+	}
+}
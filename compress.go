@@ -0,0 +1,186 @@
+package dnstrie
+
+import (
+	"strings"
+	"sync/atomic"
+)
+
+// Path compression: a chain of literal labels without rules or other children
+// along the way, e.g., "a.b.c" below "example.com" when nothing else is below
+// "example.com", is stored as a single node labeled with the whole chain in
+// name order, "a.b.c". The node is keyed in its parent by the label closest to
+// the root, "c", and holds the rules and children of the deepest label, "a".
+// Chains are split when a rule ends inside or branches off them and merged
+// again when removing a rule leaves a node with a single child.
+
+// isLiteral returns true for labels that only match themselves, which are the
+// only ones that can be part of a chain.
+func isLiteral(label string) bool {
+	return label != "+" && !isPatternChild(label)
+}
+
+// headLabel returns the label of a chain closest to the root, which is its key
+// in the children of its parent.
+func headLabel(label string) string {
+	return label[strings.LastIndexByte(label, '.')+1:]
+}
+
+// firstLabel returns the label of a chain farthest from the root, where its
+// rules end.
+func firstLabel(label string) string {
+	if dot := strings.IndexByte(label, '.'); dot >= 0 {
+		return label[:dot]
+	}
+	return label
+}
+
+// labelCount returns the number of labels of a chain.
+func labelCount(label string) int {
+	return strings.Count(label, ".") + 1
+}
+
+// joinReversed returns reversedLabels as a chain label.
+func joinReversed(reversedLabels []string) string {
+	var b strings.Builder
+	for i := len(reversedLabels) - 1; i >= 0; i-- {
+		b.WriteString(reversedLabels[i])
+		if i > 0 {
+			b.WriteByte('.')
+		}
+	}
+	return b.String()
+}
+
+// consume returns the end of the rest of domain once the labels of the chain
+// label are removed from the end of domain[:end], or false if domain[:end]
+// does not end with them.
+func consume[S string | []byte](label string, domain S, end int) (int, bool) {
+	start := end - len(label)
+	// The conversion in the comparison does not allocate.
+	if start < 0 || string(domain[start:end]) != label || start > 0 && domain[start-1] != '.' {
+		return 0, false
+	}
+	return start - 1, true
+}
+
+// commonLabels returns the number of labels of the chain of n, from its head,
+// that equal the first of reversedLabels.
+func (n *node[V]) commonLabels(reversedLabels []string) int {
+	rest, count := n.label, 0
+	for count < len(reversedLabels) {
+		dot := strings.LastIndexByte(rest, '.')
+		if rest[dot+1:] != reversedLabels[count] {
+			break
+		}
+		count++
+		if dot < 0 {
+			break
+		}
+		rest = rest[:dot]
+	}
+	return count
+}
+
+// addChild adds a child to n for the start of reversedLabels, a chain of as
+// many literal labels as possible, and returns it and the number of labels it
+// holds.
+func (n *node[V]) addChild(reversedLabels []string) (*node[V], int) {
+	count := 1
+	if isLiteral(reversedLabels[0]) {
+		for count < len(reversedLabels) && isLiteral(reversedLabels[count]) {
+			count++
+		}
+	}
+	label := intern(reversedLabels[0])
+	if count > 1 {
+		label = joinReversed(reversedLabels[:count])
+	}
+	child := newNode[V](label)
+	if n.others == nil {
+		n.others = make(nodeMap[V])
+	}
+	n.others[headLabel(label)] = child
+	if isPatternChild(label) {
+		n.patterns = append(n.patterns, label)
+	}
+	return child, count
+}
+
+// split keeps the first labels of the chain of n, from its head, in n and
+// moves the rest, with the rules and children of n, to a new child.
+func (n *node[V]) split(labels int) {
+	cut := len(n.label)
+	for i := 0; i < labels; i++ {
+		cut = strings.LastIndexByte(n.label[:cut], '.')
+	}
+	rest := *n
+	rest.label = n.label[:cut]
+	*n = node[V]{label: n.label[cut+1:], others: nodeMap[V]{headLabel(rest.label): &rest}}
+}
+
+// compact merges n, which must not be the root, with its child if n has no
+// rules and a single child and both are literal. The child is not modified, so
+// it may be shared with a copy of the trie.
+func (n *node[V]) compact() {
+	if n.rules != 0 || len(n.others) != 1 || !isLiteral(n.label) {
+		return
+	}
+	for _, child := range n.others {
+		if isLiteral(child.label) {
+			label := child.label + "." + n.label
+			*n = *child
+			n.label = label
+		}
+	}
+}
+
+// compactAll compacts every node below n and then n itself.
+func (n *node[V]) compactAll() {
+	for _, child := range n.others {
+		child.compactAll()
+	}
+	n.compact()
+}
+
+// expand returns n as a chain of nodes with a single label each, the last of
+// which has the rules and children of n. The chain is only meant to be read.
+func (n *node[V]) expand() *node[V] {
+	dot := strings.LastIndexByte(n.label, '.')
+	if dot < 0 {
+		return n
+	}
+	rest := *n
+	rest.label = n.label[:dot]
+	return &node[V]{label: n.label[dot+1:], others: nodeMap[V]{headLabel(rest.label): rest.expand()}}
+}
+
+// expandedChildren returns the children of n sorted by label, with chains
+// expanded.
+func (n *node[V]) expandedChildren() []*node[V] {
+	children := n.sortedChildren()
+	for i, child := range children {
+		children[i] = child.expand()
+	}
+	return children
+}
+
+// labelCacheSize is the number of labels intern remembers. Labels are spread
+// over the cache by hash and replace each other on collisions, so popular
+// labels are nearly always found while the cache stays small.
+const labelCacheSize = 4096
+
+var labelCache [labelCacheSize]atomic.Pointer[string]
+
+// intern returns a string equal to label, shared with the other nodes of any
+// trie with a popular label such as "com" or "www". The result never refers
+// to the memory of label, so nodes do not keep the rules they were built from
+// alive.
+func intern(label string) string {
+	slot := &labelCache[labelHash(label)%labelCacheSize]
+	if cached := slot.Load(); cached != nil && *cached == label {
+		return *cached
+	}
+	copied := string([]byte(label))
+	slot.Store(&copied)
+	return copied
+}
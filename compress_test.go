@@ -0,0 +1,216 @@
+package dnstrie
+
+import (
+	"fmt"
+	"math/rand"
+	"reflect"
+	"runtime"
+	"sort"
+	"strings"
+	"testing"
+)
+
+func TestPathCompression(t *testing.T) {
+	root, _ := MakeTrie([]string{"a.b.c.example.com"})
+	chain := root.root.others["com"]
+	if chain.label != "a.b.c.example.com" || chain.rules != exactRule || len(chain.others) != 0 {
+		t.Fatalf("MakeTrie did not compress the chain, got %+v", chain)
+	}
+	for domain, expected := range map[string]bool{
+		"a.b.c.example.com":   true,
+		"b.c.example.com":     false,
+		"xa.b.c.example.com":  false,
+		"x.a.b.c.example.com": false,
+		"com":                 false,
+	} {
+		if actual := root.Match(domain); actual != expected {
+			t.Errorf("Match(%v) got %v expected %v", domain, actual, expected)
+		}
+		if actual := root.MatchBytes([]byte(domain)); actual != expected {
+			t.Errorf("MatchBytes(%v) got %v expected %v", domain, actual, expected)
+		}
+	}
+
+	// Branching off the chain splits it and removing the branch merges it
+	// again.
+	root.Insert("+.c.example.com")
+	if split := root.root.others["com"]; split.label != "c.example.com" || split.others["b"].label != "a.b" {
+		t.Fatalf("Insert did not split the chain, got %v", root.Rules())
+	}
+	if rule, _ := root.MatchRule("a.b.c.example.com"); rule != "a.b.c.example.com" {
+		t.Errorf("MatchRule got %v", rule)
+	}
+	if rule, _ := root.MatchRule("x.b.c.example.com"); rule != "+.c.example.com" {
+		t.Errorf("MatchRule got %v", rule)
+	}
+	if !root.Remove("+.c.example.com") {
+		t.Fatal("Remove failed")
+	}
+	if chain := root.root.others["com"]; chain.label != "a.b.c.example.com" {
+		t.Errorf("Remove did not merge the chain, got %+v", chain)
+	}
+	if root.Remove("c.example.com") || root.Remove("b.c.example.com") {
+		t.Error("Remove removed a rule ending inside a chain")
+	}
+
+	var rules []string
+	root.Walk(func(rule string, n Node[struct{}]) bool {
+		rules = append(rules, fmt.Sprintf("%v %v %d", rule, n.Label(), n.Depth()))
+		return true
+	})
+	if expected := []string{"a.b.c.example.com a 5"}; !reflect.DeepEqual(rules, expected) {
+		t.Errorf("Walk got %v expected %v", rules, expected)
+	}
+	expected := `tree
++-- com
+    +-- example
+        +-- c
+            +-- b
+                +-- a`
+	if actual := root.String(); actual != expected {
+		t.Errorf("String() got\n%v\nexpected\n%v", actual, expected)
+	}
+}
+
+// randomRules returns rules over a few labels, so that chains are often split
+// and merged.
+func randomRules(r *rand.Rand, n int) []string {
+	labels := []string{"a", "b", "c", "com", "net"}
+	prefixes := []string{"", "", "", "+.", "*.", "=2.", "!", "!+."}
+	rules := make([]string, n)
+	for i := range rules {
+		rule := labels[3+r.Intn(2)]
+		for depth := r.Intn(5); depth > 0; depth-- {
+			rule = labels[r.Intn(len(labels))] + "." + rule
+		}
+		rules[i] = prefixes[r.Intn(len(prefixes))] + rule
+	}
+	return rules
+}
+
+// TestPathCompressionRandom checks that adding and removing rules in any
+// order leaves the same trie as building it from the remaining rules.
+func TestPathCompressionRandom(t *testing.T) {
+	r := rand.New(rand.NewSource(1))
+	var names []string
+	for _, rule := range randomRules(r, 200) {
+		parsed, _ := ParseRule(rule)
+		name := strings.Join(parsed.Labels, ".")
+		names = append(names, name, "x."+name)
+	}
+	for round := 0; round < 20; round++ {
+		root := New()
+		s := NewSyncTrie(New())
+		present := map[string]bool{}
+		for _, rule := range randomRules(r, 300) {
+			before := s.Load()
+			beforeRules := before.Rules()
+			if r.Intn(3) == 0 && len(present) > 0 {
+				for p := range present {
+					rule = p
+					break
+				}
+				delete(present, rule)
+				root.Remove(rule)
+				s.Remove(rule)
+			} else {
+				present[rule] = true
+				root.Insert(rule)
+				s.Insert(rule)
+			}
+			if !reflect.DeepEqual(before.Rules(), beforeRules) {
+				t.Fatalf("SyncTrie changed a previous version adding or removing %v", rule)
+			}
+
+			var rules []string
+			for p := range present {
+				rules = append(rules, p)
+			}
+			sort.Strings(rules)
+			expected, _ := MakeTrie(rules)
+			if !reflect.DeepEqual(root.root, expected.root) || root.exceptions != expected.exceptions {
+				t.Fatalf("after %v got\n%v\nexpected\n%v", rule, root, expected)
+			}
+			if !reflect.DeepEqual(s.Load().root, expected.root) {
+				t.Fatalf("SyncTrie after %v got\n%v\nexpected\n%v", rule, s.Load(), expected)
+			}
+		}
+		expected := New()
+		for rule := range present {
+			expected.Insert(rule)
+		}
+		compiled := root.Freeze()
+		thawed := compiled.Thaw()
+		if !reflect.DeepEqual(thawed.root, root.root) {
+			t.Fatalf("Thaw got\n%v\nexpected\n%v", thawed, root)
+		}
+		for _, name := range names {
+			if root.Match(name) != expected.Match(name) || compiled.Match(name) != expected.Match(name) {
+				t.Fatalf("Match(%v) differs", name)
+			}
+		}
+	}
+}
+
+// realisticRules returns n rules shaped like a large blocklist: mostly
+// registered domains, some with "www", wildcards and a few deeper hosts below
+// popular labels.
+func realisticRules(n int) []string {
+	tlds := []string{"com", "com", "com", "net", "org", "info", "io", "co.uk", "com.br", "ru"}
+	hosts := []string{"www", "cdn", "api", "static", "img", "ads", "track", "m"}
+	r := rand.New(rand.NewSource(1))
+	rules := make([]string, n)
+	for i := range rules {
+		domain := fmt.Sprintf("d%x.%s", r.Int31(), tlds[r.Intn(len(tlds))])
+		switch p := r.Intn(100); {
+		case p < 55:
+			rules[i] = domain
+		case p < 70:
+			rules[i] = "www." + domain
+		case p < 80:
+			rules[i] = "+." + domain
+		case p < 85:
+			rules[i] = "*." + domain
+		default:
+			rules[i] = hosts[r.Intn(len(hosts))] + "." + hosts[r.Intn(len(hosts))] + "." + domain
+		}
+	}
+	return rules
+}
+
+// BenchmarkMillionRules reports the heap used per rule and the number of
+// nodes of a DomainTrie of a million rules.
+func BenchmarkMillionRules(b *testing.B) {
+	rules := realisticRules(1000000)
+	for i := 0; i < b.N; i++ {
+		before := heapInUse()
+		root, err := MakeTrie(rules)
+		if err != nil {
+			b.Fatal(err)
+		}
+		after := heapInUse()
+		b.ReportMetric(float64(after-before)/float64(len(rules)), "B/rule")
+		b.ReportMetric(float64(root.Stats().Nodes), "nodes")
+		runtime.KeepAlive(root)
+	}
+}
+
+func BenchmarkMillionRulesMatch(b *testing.B) {
+	rules := realisticRules(1000000)
+	root, err := MakeTrie(rules)
+	if err != nil {
+		b.Fatal(err)
+	}
+	queries := make([]string, 1024)
+	for i := range queries {
+		if i%2 == 0 {
+			queries[i] = "x." + rules[(i*7919)%len(rules)]
+		} else {
+			queries[i] = fmt.Sprintf("miss%d.com", i)
+		}
+	}
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		root.Match(queries[i%len(queries)])
+	}
+}
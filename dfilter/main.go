@@ -3,9 +3,9 @@ package main
 import (
 	"bufio"
 	"fmt"
-	"io/ioutil"
 	"os"
-	"strings"
+	"sort"
+	"text/tabwriter"
 
 	"github.com/urfave/cli/v2"
 	"github.com/ynadji/dnstrie"
@@ -13,29 +13,55 @@ import (
 
 var root *dnstrie.DomainTrie
 
-func readDomains(matchFilePath string) []string {
+// makeTrie builds the trie of the matches file with the global options.
+func makeTrie(c *cli.Context) (*dnstrie.DomainTrie, error) {
+	matchFilePath := c.String("matches")
 	f, err := os.Open(matchFilePath)
 	if err != nil {
-		panic(fmt.Sprintf("Failed to read %s: %v", matchFilePath, err))
+		return nil, fmt.Errorf("Failed to read %s: %v", matchFilePath, err)
 	}
-	reader := bufio.NewReader(f)
-	content, _ := ioutil.ReadAll(reader)
-	return strings.Split(strings.TrimSpace(string(content)), "\n")
+	defer f.Close()
+	var opts []dnstrie.Option
+	if c.Bool("strict") {
+		opts = append(opts, dnstrie.WithStrictValidation())
+	}
+	if c.Bool("normalize") {
+		opts = append(opts, dnstrie.WithNormalization(), dnstrie.WithTrailingDotTolerance())
+	}
+	opts = append(opts, dnstrie.WithWorkers(c.Int("workers")))
+	if rate := c.Float64("prefilter"); rate > 0 {
+		opts = append(opts, dnstrie.WithPrefilter(rate))
+	}
+	root, err := dnstrie.BuildFromReader(f, opts...)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to make trie: %v", err)
+	}
+	return root, nil
 }
 
 func run(c *cli.Context) error {
-	domains := readDomains(c.String("matches"))
-	root, err := dnstrie.MakeTrie(domains)
+	root, err := makeTrie(c)
 	if err != nil {
-		return fmt.Errorf("Failed to make trie: %v", err)
+		return err
 	}
+	domains := make(chan string)
 	scanner := bufio.NewScanner(os.Stdin)
-	for scanner.Scan() {
-		domain := scanner.Text()
-		matched := root.Match(domain)
+	go func() {
+		for scanner.Scan() {
+			domains <- scanner.Text()
+		}
+		close(domains)
+	}()
+	for r := range root.MatchStream(domains) {
+		domain, matched := r.Domain, r.Matched
 
 		if matched && !c.Bool("complement") {
-			fmt.Printf("%v\n", domain)
+			if c.Bool("rule") {
+				rule, _ := root.MatchRule(domain)
+				fmt.Printf("%v\t%v\n", domain, rule)
+			} else {
+				fmt.Printf("%v\n", domain)
+			}
 		} else if !matched && c.Bool("complement") {
 			fmt.Printf("%v\n", domain)
 		}
@@ -46,6 +72,58 @@ func run(c *cli.Context) error {
 	return nil
 }
 
+func export(c *cli.Context) error {
+	root, err := makeTrie(c)
+	if err != nil {
+		return err
+	}
+	switch format := c.String("format"); format {
+	case "dot":
+		return root.WriteDOT(os.Stdout, c.String("zone"))
+	case "json":
+		return root.WriteJSON(os.Stdout, c.String("zone"))
+	default:
+		return fmt.Errorf("Unknown export format %v, expected dot or json", format)
+	}
+}
+
+func stats(c *cli.Context) error {
+	root, err := makeTrie(c)
+	if err != nil {
+		return err
+	}
+	s := root.Stats()
+	w := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+	fmt.Fprintf(w, "rules\t%d\n", s.Rules)
+	fmt.Fprintf(w, "nodes\t%d\n", s.Nodes)
+	fmt.Fprintf(w, "wildcard nodes\t%d\n", s.WildcardNodes)
+	fmt.Fprintf(w, "max depth\t%d\n", s.MaxDepth)
+	fmt.Fprintf(w, "mean depth\t%.2f\n", s.MeanDepth)
+	zone := s.MaxFanoutZone
+	if zone == "" {
+		zone = "."
+	}
+	fmt.Fprintf(w, "widest fan-out\t%d (%v)\n", s.MaxFanout, zone)
+	fmt.Fprintf(w, "estimated memory\t%d bytes\n", s.Memory)
+	if err := w.Flush(); err != nil {
+		return err
+	}
+
+	tldStats := root.TLDStats()
+	tlds := make([]string, 0, len(tldStats))
+	for tld := range tldStats {
+		tlds = append(tlds, tld)
+	}
+	sort.Strings(tlds)
+	fmt.Println()
+	fmt.Fprintln(w, "tld\trules\tnodes\twildcard nodes\tmax depth\tmean depth\testimated memory")
+	for _, tld := range tlds {
+		s := tldStats[tld]
+		fmt.Fprintf(w, "%v\t%d\t%d\t%d\t%d\t%.2f\t%d\n", tld, s.Rules, s.Nodes, s.WildcardNodes, s.MaxDepth, s.MeanDepth, s.Memory)
+	}
+	return w.Flush()
+}
+
 func main() {
 	app := &cli.App{
 		Name:   "dfilter",
@@ -56,7 +134,7 @@ func main() {
 	app.Flags = []cli.Flag{
 		&cli.StringFlag{
 			Name:     "matches",
-			Usage:    "Path to file of domain matches, one per line.",
+			Usage:    "Path to file of domain matches, one per line. Blank lines and lines starting with # are ignored.",
 			Required: true,
 		},
 		&cli.BoolFlag{
@@ -64,6 +142,55 @@ func main() {
 			Usage:   "Invert matches",
 			Aliases: []string{"c"},
 		},
+		&cli.BoolFlag{
+			Name:    "rule",
+			Usage:   "Print the most specific matching rule after each domain",
+			Aliases: []string{"r"},
+		},
+		&cli.BoolFlag{
+			Name:  "strict",
+			Usage: "Reject the matches file if any match is malformed, listing every bad match",
+		},
+		&cli.BoolFlag{
+			Name:    "normalize",
+			Usage:   "Lowercase, trim and punycode matches and domains and ignore trailing dots",
+			Aliases: []string{"n"},
+		},
+		&cli.IntFlag{
+			Name:    "workers",
+			Usage:   "Number of goroutines matching domains, 0 for one per CPU",
+			Aliases: []string{"w"},
+		},
+		&cli.Float64Flag{
+			Name:  "prefilter",
+			Usage: "Reject most domains matching nothing with a Bloom filter at this false positive rate, e.g., 0.01, 0 to disable",
+		},
+	}
+
+	app.Commands = []*cli.Command{
+		{
+			Name:   "stats",
+			Usage:  "Print the size and shape of the trie of the matches file, in total and per TLD",
+			Action: stats,
+		},
+		{
+			Name:   "export",
+			Usage:  "Write the trie of the matches file as Graphviz DOT or JSON",
+			Action: export,
+			Flags: []cli.Flag{
+				&cli.StringFlag{
+					Name:    "format",
+					Usage:   "Output format, dot or json",
+					Value:   "dot",
+					Aliases: []string{"f"},
+				},
+				&cli.StringFlag{
+					Name:    "zone",
+					Usage:   "Only write the subtree of this zone",
+					Aliases: []string{"z"},
+				},
+			},
+		},
 	}
 
 	if err := app.Run(os.Args); err != nil {
@@ -14,6 +14,29 @@
 //             +-- *
 // Where google.com, web.google.com (and all its children) and anything under
 // but not including org, mail.google.com match the tree (with `tree.Match`).
+//
+// Rules starting with "!" are exceptions, e.g., "+.ads.example.com" and
+// "!safe.ads.example.com" match every name under ads.example.com except
+// safe.ads.example.com. The most specific rule for a name decides if it
+// matches, so a rule below an exception can match names again.
+//
+// A "*" that is not the first label matches exactly one label at its position,
+// e.g., "api.*.corp.example.com" matches api.dev.corp.example.com but not
+// api.corp.example.com. Labels can also be glob patterns using "*", "?" and
+// character classes like "[0-9]", e.g., "cdn-*.example.net". A literal label
+// is more specific than a pattern in the same position, and patterns are tried
+// in the order they were added.
+//
+// "+N." and "=N." rules are wildcards bounded by depth: "+2.example.com"
+// matches names one or two labels below example.com and "=1.example.com" names
+// exactly one label below it. A wildcard matching fewer depths is more
+// specific, e.g., "!+.ads.example.com" and "=1.ads.example.com" only match the
+// children of ads.example.com.
+//
+// Chains of labels without rules or branches along the way, like "a.b" in
+// "a.b.c.example.com", are stored as a single node, and popular labels like
+// "com" and "www" share their memory, so large lists take less space. `String`
+// and the exporters still show one label per level, as above.
 package dnstrie
 
 import (
@@ -21,119 +44,768 @@ import (
 	"strings"
 )
 
-// DomainTrie is a struct for the recursive DNS-aware trie data structure. The
-// three members represent the current label ("." for the root), the list of
-// children and if this label can be considered an ending state for the tree (to
-// identify that there is an exact domain match at this point). This should not
-// be used directly and should instead be created using `dnstrie.MakeTrie`.
+// Trie is a DNS-aware trie that associates a value of type V with every rule
+// added to it, e.g., the list, category or severity a rule came from. Use
+// `dnstrie.NewTrie` to create one and `Add` to populate it. DomainTrie is the
+// value-less variant used for plain filtering.
+type Trie[V any] struct {
+	root node[V]
+	// exceptions counts the exception rules in the trie. Without any,
+	// matching can stop at the first wildcard.
+	exceptions int
+	opts       options
+	// filter holds the zones of the rules, see WithPrefilter.
+	filter *prefilter
+}
+
+// node is a single label of the recursive trie. The members represent the
+// current label ("." for the root), the children keyed by their label, if this
+// label can be considered an ending state for the tree (to identify that there
+// is an exact domain match at this point) or is excluded by an exception, the
+// kinds of rules ending here and the value of the rule ending here. A "*." rule
+// makes its parent an ending state without adding an exact rule for it, which
+// `rules` distinguishes. For "+" nodes, `end` and `except` apply to every
+// name below the parent. Children whose label is a pattern matching other
+// labels are also listed in `patterns`, in the order they are tried.
+type node[V any] struct {
+	label    string
+	others   nodeMap[V]
+	patterns []string
+	end      bool
+	except   bool
+	rules    uint8
+	value    V
+}
+
+// Kinds of rules that can end at a node. exactRule is only set on label nodes,
+// childrenRule ("+.") and subtreeRule ("*.") only on "+" nodes, except that
+// depth-bounded wildcards ("+2." and "=1.") are childrenRule on a node labeled
+// with their bound. Exception rules ("!") are the same kinds shifted by
+// exceptionShift.
+const (
+	exactRule uint8 = 1 << iota
+	childrenRule
+	subtreeRule
+	exceptExactRule
+	exceptChildrenRule
+	exceptSubtreeRule
+
+	exceptionShift = 3
+	exceptionRules = exceptExactRule | exceptChildrenRule | exceptSubtreeRule
+)
+
+// nodeMap maps a child's label to its node. Nodes near the root of real
+// blocklists (e.g., "com") have hundreds of thousands of children, so lookups
+// must not depend on the fan-out. Leaves never allocate a map.
+type nodeMap[V any] map[string]*node[V]
+
+// DomainTrie is a Trie whose rules carry no values. Create an empty one with
+// `New`, or one holding a list of rules with `MakeTrie` or `BuildFromReader`,
+// all configured with options such as WithNormalization. `MakeTrieFromRules`
+// builds one from parsed rules.
 type DomainTrie struct {
-	label  string
-	others domainTrieSlice
-	end    bool
+	Trie[struct{}]
 }
 
-type domainTrieSlice []*DomainTrie
+// NewTrie returns an empty Trie configured with opts.
+func NewTrie[V any](opts ...Option) *Trie[V] {
+	t := &Trie[V]{root: node[V]{label: "."}}
+	for _, opt := range opts {
+		opt(&t.opts)
+	}
+	return t
+}
 
 // Empty returns true if nothing has been added to the trie and true otherwise.
-func (root *DomainTrie) Empty() bool {
-	return root.others == nil && !root.end
+func (t *Trie[V]) Empty() bool {
+	return t.root.others == nil && t.root.rules == 0
 }
 
 // Match matches against exactly fully qualified domain names and zone
-// wildcards.
-func (root *DomainTrie) Match(domain string) bool {
-	reversedLabels, err := reverseLabelSlice(domain)
-	if err != nil {
+// wildcards. The most specific matching rule decides, so an exception only
+// unmatches names it is more specific for. The domain is scanned label by label
+// from its end, so matching does not allocate.
+func (t *Trie[V]) Match(domain string) bool {
+	return match(t, t.opts.name(domain))
+}
+
+// MatchBytes is like Match but takes the domain as a byte slice, e.g., a name
+// decoded in place from a packet buffer. It does not allocate.
+func (t *Trie[V]) MatchBytes(domain []byte) bool {
+	if t.opts.normalize && !normalized(domain) {
+		return t.Match(string(domain))
+	}
+	if t.opts.trailingDot {
+		domain = trimTrailingDot(domain)
+	}
+	return match(t, domain)
+}
+
+func match[V any, S string | []byte](t *Trie[V], domain S) bool {
+	if t.filter != nil && !mayMatch(t.filter, domain) {
 		return false
 	}
-	curr := root
-	for _, label := range reversedLabels {
-		node := findNode("+", curr.others)
-		if node != nil {
-			return true
+	matched, _ := matchNode(t, &t.root, domain, len(domain))
+	return matched
+}
+
+// matchNode decides if domain[:end] matches below n, the node of the rest of
+// domain, and returns false for ok if no rule below n decides. Rules deeper in
+// the tree are more specific, so they are searched first, and a literal label
+// is more specific than a single label wildcard.
+func matchNode[V any, S string | []byte](t *Trie[V], n *node[V], domain S, end int) (matched, ok bool) {
+	if end < 0 {
+		return n.end, n.end || n.except
+	}
+	wildcard := findNode("+", n.others)
+	if wildcard != nil && wildcard.end && t.exceptions == 0 {
+		return true, true
+	}
+	start := lastLabel(domain, end)
+	label := domain[start:end]
+	// The conversion in the map index expression does not allocate.
+	child := n.others[string(label)]
+	if child != nil {
+		if rest, ok := consume(child.label, domain, end); ok {
+			if matched, ok := matchNode(t, child, domain, rest); ok {
+				return matched, true
+			}
+		}
+	}
+	for _, pattern := range n.patterns {
+		if p := n.others[pattern]; p != child && matchLabel(pattern, label) {
+			if matched, ok := matchNode(t, p, domain, start-1); ok {
+				return matched, true
+			}
+		}
+	}
+	if w := decidingWildcard(n, wildcard, domain, end); w != nil {
+		return w.end, true
+	}
+	return false, false
+}
+
+// decidingWildcard returns the most specific wildcard child of n deciding if
+// domain[:end] matches, i.e., the "+" child or a depth-bounded wildcard whose
+// depth range includes the number of labels of domain[:end], or nil if none
+// does. wildcard is the "+" child of n.
+func decidingWildcard[V any, S string | []byte](n, wildcard *node[V], domain S, end int) *node[V] {
+	labels := 0
+	if len(n.patterns) > 0 {
+		labels = countLabels(domain, end)
+	}
+	return wildcardAt(n, wildcard, labels)
+}
+
+// wildcardAt is like decidingWildcard for names labels below n.
+func wildcardAt[V any](n, wildcard *node[V], labels int) *node[V] {
+	var best *node[V]
+	var bestSpan wildcardSpan
+	if wildcard != nil && (wildcard.end || wildcard.except) {
+		best, bestSpan = wildcard, wildcardSpan{1, maxLabels, wildcard.except}
+	}
+	for _, pattern := range n.patterns {
+		min, max, ok := bounds(pattern)
+		if !ok {
+			continue
+		}
+		c := n.others[pattern]
+		span := wildcardSpan{min, max, c.except}
+		if span.covers(labels) && (c.end || c.except) && (best == nil || span.narrower(bestSpan)) {
+			best, bestSpan = c, span
 		}
-		node = findNode(label, curr.others)
-		if node == nil {
+	}
+	return best
+}
+
+// maxLabels is the largest number of labels in a name.
+const maxLabels = 127
+
+// wildcardSpan is the range of depths below its zone that a wildcard matches
+// and if it is an exception.
+type wildcardSpan struct {
+	min, max int
+	except   bool
+}
+
+func (s wildcardSpan) covers(labels int) bool {
+	return s.min <= labels && labels <= s.max
+}
+
+// narrower returns true if s is more specific than o: it matches fewer depths
+// or, for the same depths, is an exception.
+func (s wildcardSpan) narrower(o wildcardSpan) bool {
+	if s.max != o.max {
+		return s.max < o.max
+	}
+	if s.min != o.min {
+		return s.min > o.min
+	}
+	return s.except && !o.except
+}
+
+// bounds returns the least and greatest number of labels below its zone that
+// a depth-bounded wildcard label matches, e.g., 1 and 2 for "+2" and 1 and 1
+// for "=1", and false for other labels.
+func bounds[S string | []byte](label S) (min, max int, ok bool) {
+	if len(label) < 2 || label[0] != '+' && label[0] != '=' {
+		return 0, 0, false
+	}
+	depth := 0
+	for i := 1; i < len(label); i++ {
+		if label[i] < '0' || label[i] > '9' {
+			return 0, 0, false
+		}
+		if depth = 10*depth + int(label[i]-'0'); depth > maxLabels {
+			return 0, 0, false
+		}
+	}
+	switch {
+	case depth == 0:
+		return 0, 0, false
+	case label[0] == '=':
+		return depth, depth, true
+	}
+	return 1, depth, true
+}
+
+// countLabels returns the number of labels of domain[:end].
+func countLabels[S string | []byte](domain S, end int) int {
+	labels := 1
+	for i := 0; i < end; i++ {
+		if domain[i] == '.' {
+			labels++
+		}
+	}
+	return labels
+}
+
+// lastLabel returns the start of the last label of domain[:end].
+func lastLabel[S string | []byte](domain S, end int) int {
+	start := end - 1
+	for start >= 0 && domain[start] != '.' {
+		start--
+	}
+	return start + 1
+}
+
+// matchLabel returns true if label matches the single label pattern. "*"
+// matches any run of characters, "?" any single character and "[...]" one
+// character of a class like "[a-f0-9]", or not of it with a leading "!".
+func matchLabel[S string | []byte](pattern string, label S) bool {
+	p, l := 0, 0
+	// star is the position of the last "*" in pattern and retry the
+	// position in label to resume from if the rest does not match.
+	star, retry := -1, 0
+	for p < len(pattern) || l < len(label) {
+		if p < len(pattern) {
+			switch pattern[p] {
+			case '*':
+				star, retry = p, l+1
+				p++
+				continue
+			case '?':
+				if l < len(label) {
+					p++
+					l++
+					continue
+				}
+			case '[':
+				if l < len(label) {
+					if matched, next := matchClass(pattern, p, label[l]); matched {
+						p = next
+						l++
+						continue
+					}
+				}
+			default:
+				if l < len(label) && pattern[p] == label[l] {
+					p++
+					l++
+					continue
+				}
+			}
+		}
+		if star < 0 || retry > len(label) {
 			return false
 		}
-		curr = node
+		p, l = star+1, retry
+		retry++
 	}
-	return curr.end
+	return true
 }
 
-func findNode(label string, others domainTrieSlice) *DomainTrie {
-	for _, trie := range others {
-		if trie.label == label {
-			return trie
+// matchClass returns if c matches the character class starting at
+// pattern[start] and the position after it. An unterminated class never
+// matches.
+func matchClass(pattern string, start int, c byte) (bool, int) {
+	end := classEnd(pattern, start)
+	if end < 0 {
+		return false, len(pattern)
+	}
+	i := start + 1
+	negated := pattern[i] == '!'
+	if negated {
+		i++
+	}
+	matched := false
+	for ; i < end; i++ {
+		lo, hi := pattern[i], pattern[i]
+		if i+2 < end && pattern[i+1] == '-' {
+			hi = pattern[i+2]
+			i += 2
+		}
+		matched = matched || lo <= c && c <= hi
+	}
+	return matched != negated, end + 1
+}
+
+// classEnd returns the position of the "]" closing the character class
+// starting at pattern[start], or -1 if there is none. A "]" right after the
+// opening "[" or "[!" is part of the class.
+func classEnd(pattern string, start int) int {
+	i := start + 1
+	if i < len(pattern) && pattern[i] == '!' {
+		i++
+	}
+	if i < len(pattern) && pattern[i] == ']' {
+		i++
+	}
+	if end := strings.IndexByte(pattern[i:], ']'); end >= 0 {
+		return i + end
+	}
+	return -1
+}
+
+// isPattern returns true if label matches other labels than itself.
+func isPattern(label string) bool {
+	return strings.ContainsAny(label, "*?[")
+}
+
+// isPatternChild returns true if a child labeled label is listed in the
+// patterns of its parent: it is a pattern or a depth-bounded wildcard.
+func isPatternChild(label string) bool {
+	_, _, bounded := bounds(label)
+	return bounded || isPattern(label)
+}
+
+// checkPattern returns an error if a character class of label is not
+// terminated.
+func checkPattern(label string) error {
+	for i := 0; i < len(label); i++ {
+		if label[i] != '[' {
+			continue
+		}
+		if i = classEnd(label, i); i < 0 {
+			return fmt.Errorf("Failed to parse label %v: unterminated character class", label)
 		}
 	}
 	return nil
 }
 
-func checkAndRemoveWildcard(domain string) (string, string) {
-	if len(domain) < 2 {
-		return domain, ""
+// decision is the rule deciding if a name matches: the node it ends at, its
+// kind and, if requested, the labels of its zone below the node the search
+// started from, most specific first.
+type decision[V any] struct {
+	node   *node[V]
+	kind   uint8
+	labels []string
+}
+
+// rule returns the text of the rule.
+func (d *decision[V]) rule() string {
+	prefix := kindPrefix(d.kind)
+	if _, _, bounded := bounds(d.node.label); bounded && prefix == "+" {
+		prefix = d.node.label
+	}
+	return joinRule(prefix, strings.Join(d.labels, "."))
+}
+
+// decide is like matchNode but returns the deciding rule.
+func (t *Trie[V]) decide(n *node[V], domain string, end int, withLabels bool) (decision[V], bool) {
+	if end < 0 {
+		star := findNode("+", n.others)
+		switch {
+		case n.rules&exceptExactRule != 0:
+			return decision[V]{node: n, kind: exceptExactRule}, true
+		case n.rules&exactRule != 0:
+			return decision[V]{node: n, kind: exactRule}, true
+		case star != nil && star.rules&exceptSubtreeRule != 0:
+			return decision[V]{node: star, kind: exceptSubtreeRule}, true
+		case star != nil && star.rules&subtreeRule != 0:
+			return decision[V]{node: star, kind: subtreeRule}, true
+		}
+		return decision[V]{}, false
+	}
+	start := lastLabel(domain, end)
+	label := domain[start:end]
+	child := findNode(label, n.others)
+	// Try the literal child first, then every matching pattern.
+	for i := -1; i < len(n.patterns); i++ {
+		c, rest := child, start-1
+		if i >= 0 {
+			if c = n.others[n.patterns[i]]; c == child || !matchLabel(n.patterns[i], label) {
+				continue
+			}
+		} else if c != nil {
+			var ok bool
+			if rest, ok = consume(c.label, domain, end); !ok {
+				continue
+			}
+		}
+		if c == nil {
+			continue
+		}
+		if d, ok := t.decide(c, domain, rest, withLabels); ok {
+			if withLabels {
+				d.labels = append(d.labels, c.label)
+			}
+			return d, true
+		}
 	}
-	if domain[1] == '.' && (domain[0] == '*' || domain[0] == '+') {
-		return domain[2:], string(domain[0])
+	switch w := decidingWildcard(n, findNode("+", n.others), domain, end); {
+	case w == nil:
+	case w.label != "+":
+		if w.except {
+			return decision[V]{node: w, kind: exceptChildrenRule}, true
+		}
+		return decision[V]{node: w, kind: childrenRule}, true
+	default:
+		for _, kind := range []uint8{exceptChildrenRule, exceptSubtreeRule, childrenRule, subtreeRule} {
+			if w.rules&kind != 0 {
+				return decision[V]{node: w, kind: kind}, true
+			}
+		}
 	}
+	return decision[V]{}, false
+}
 
-	return domain, ""
+// Lookup returns the value of the most specific rule matching domain and true,
+// or the zero value and false if nothing matches or an exception is the most
+// specific rule. An exact rule is more specific than a wildcard on the same
+// name, and a wildcard deeper in the tree is more specific than one closer to
+// the root.
+func (t *Trie[V]) Lookup(domain string) (V, bool) {
+	domain = t.opts.name(domain)
+	if t.filter != nil && !mayMatch(t.filter, domain) {
+		var zero V
+		return zero, false
+	}
+	d, ok := t.decide(&t.root, domain, len(domain), false)
+	if !ok || d.kind&exceptionRules != 0 {
+		var zero V
+		return zero, false
+	}
+	return d.node.value, true
 }
 
-func reverseLabelSlice(domain string) ([]string, error) {
-	var reversedLabels []string
-	domain, wildcard := checkAndRemoveWildcard(domain)
-	labels := strings.Split(domain, ".")
+// MatchRule returns the most specific rule matching domain, e.g.,
+// "www.google.com" rather than "+.google.com" when both are present, and
+// true, or false if nothing matches or an exception is the most specific rule.
+// Rules are rebuilt from the trie, so a "*." rule is returned as such rather
+// than as its implied exact parent.
+func (t *Trie[V]) MatchRule(domain string) (string, bool) {
+	domain = t.opts.name(domain)
+	if t.filter != nil && !mayMatch(t.filter, domain) {
+		return "", false
+	}
+	d, ok := t.decide(&t.root, domain, len(domain), true)
+	if !ok || d.kind&exceptionRules != 0 {
+		return "", false
+	}
+	return d.rule(), true
+}
+
+// MatchAll returns every exact and wildcard rule matching domain along its
+// paths from the root, from least to most specific along each path. Exception
+// rules are included with their leading "!".
+func (t *Trie[V]) MatchAll(domain string) []string {
+	domain = t.opts.name(domain)
+	var rules []string
+	t.walkRules(&t.root, domain, len(domain), nil, func(d decision[V]) {
+		rule := d.rule()
+		if d.kind&exceptionRules != 0 {
+			rule = "!" + rule
+		}
+		rules = append(rules, rule)
+	})
+	return rules
+}
+
+// walkRules calls visit for every rule matching domain[:end] below n, from
+// least to most specific along every path. zone holds the labels from the root
+// to n. An exception is more specific than a rule of the same kind on the same
+// zone.
+func (t *Trie[V]) walkRules(n *node[V], domain string, end int, zone []string, visit func(decision[V])) {
+	labels := func() []string {
+		labels := make([]string, len(zone))
+		for i, label := range zone {
+			labels[len(zone)-1-i] = label
+		}
+		return labels
+	}
+	wildcard := findNode("+", n.others)
+	if end < 0 {
+		for _, kind := range []uint8{subtreeRule, exceptSubtreeRule} {
+			if wildcard != nil && wildcard.rules&kind != 0 {
+				visit(decision[V]{wildcard, kind, labels()})
+			}
+		}
+		for _, kind := range []uint8{exactRule, exceptExactRule} {
+			if n.rules&kind != 0 {
+				visit(decision[V]{n, kind, labels()})
+			}
+		}
+		return
+	}
+	if wildcard != nil {
+		for _, kind := range []uint8{subtreeRule, childrenRule, exceptSubtreeRule, exceptChildrenRule} {
+			if wildcard.rules&kind != 0 {
+				visit(decision[V]{wildcard, kind, labels()})
+			}
+		}
+	}
+	for _, pattern := range n.patterns {
+		min, max, ok := bounds(pattern)
+		if !ok || !(wildcardSpan{min: min, max: max}).covers(countLabels(domain, end)) {
+			continue
+		}
+		bounded := n.others[pattern]
+		for _, kind := range []uint8{childrenRule, exceptChildrenRule} {
+			if bounded.rules&kind != 0 {
+				visit(decision[V]{bounded, kind, labels()})
+			}
+		}
+	}
+	start := lastLabel(domain, end)
+	label := domain[start:end]
+	child := findNode(label, n.others)
+	if child != nil {
+		if rest, ok := consume(child.label, domain, end); ok {
+			t.walkRules(child, domain, rest, append(zone, child.label), visit)
+		}
+	}
+	for _, pattern := range n.patterns {
+		if p := n.others[pattern]; p != child && matchLabel(pattern, label) {
+			t.walkRules(p, domain, start-1, append(zone, p.label), visit)
+		}
+	}
+}
 
-	for i := len(labels) - 1; i >= 0; i-- {
-		reversedLabels = append(reversedLabels, labels[i])
+// kindPrefix returns the wildcard prefix of rules of the given kind.
+func kindPrefix(kind uint8) string {
+	switch kind&^exceptionRules | kind>>exceptionShift {
+	case childrenRule:
+		return "+"
+	case subtreeRule:
+		return "*"
 	}
+	return ""
+}
 
-	if wildcard != "" {
-		reversedLabels = append(reversedLabels, wildcard)
+func joinRule(prefix, zone string) string {
+	switch {
+	case prefix == "":
+		return zone
+	case zone == "":
+		return prefix
 	}
+	return prefix + "." + zone
+}
 
-	return reversedLabels, nil
+func findNode[V any](label string, others nodeMap[V]) *node[V] {
+	return others[label]
 }
 
-func addReversedLabelsToTrie(root *DomainTrie, reversedLabels []string) {
-	curr := root
-	for _, label := range reversedLabels {
-		node := findNode(label, curr.others)
-		if node == nil {
-			node = &DomainTrie{label, domainTrieSlice{}, false}
-			curr.others = append(curr.others, node)
+// path returns the nodes from the root to the end of reversedLabels. If create
+// is true, missing nodes are created and chains are split where reversedLabels
+// ends or branches off inside them, otherwise nil is returned.
+func (t *Trie[V]) path(reversedLabels []string, create bool) []*node[V] {
+	path := make([]*node[V], 0, len(reversedLabels)+1)
+	curr := &t.root
+	path = append(path, curr)
+	for i := 0; i < len(reversedLabels); {
+		node := findNode(reversedLabels[i], curr.others)
+		switch {
+		case node == nil && !create:
+			return nil
+		case node == nil:
+			var labels int
+			node, labels = curr.addChild(reversedLabels[i:])
+			i += labels
+		default:
+			labels := node.commonLabels(reversedLabels[i:])
+			if labels < labelCount(node.label) {
+				if !create {
+					return nil
+				}
+				node.split(labels)
+			}
+			i += labels
 		}
 		curr = node
+		path = append(path, curr)
+	}
+	return path
+}
+
+func newNode[V any](label string) *node[V] {
+	return &node[V]{label: label}
+}
+
+// Add adds a rule to the trie and associates value with it. Adding a rule that
+// is already present replaces its value. A "*." rule and a "+." rule for the
+// same zone share a value. Rules starting with "!" are exceptions: the names
+// they match do not match the trie unless a more specific rule matches them
+// again. Exceptions have no value.
+func (t *Trie[V]) Add(rule string, value V) error {
+	reversedLabels, kind, err := t.parse(rule)
+	if err != nil {
+		return fmt.Errorf("Failed to add %v: %v", rule, err)
+	}
+	t.add(reversedLabels, kind, value)
+	return nil
+}
+
+// parse is like parseRule but prepares rule as configured for t.
+func (t *Trie[V]) parse(rule string) ([]string, uint8, error) {
+	r, err := t.opts.parseRule(rule)
+	if err != nil {
+		return nil, 0, err
 	}
-	curr.end = true
+	return r.reversed()
 }
 
-// MakeTrie returns the root of a trie given a slice of domain names.  Use
-// dns.Normalize to prepare domains received from untrusted or unreliable
-// sources.
-func MakeTrie(domains []string) (*DomainTrie, error) {
-	root := &DomainTrie{label: "."}
+// add adds the rule of the given kind ending at reversedLabels.
+func (t *Trie[V]) add(reversedLabels []string, kind uint8, value V) {
+	path := t.path(reversedLabels, true)
+	last := path[len(path)-1]
+	if kind&exceptionRules == 0 {
+		last.value = value
+	} else if last.rules&kind == 0 {
+		t.exceptions++
+	}
+	last.rules |= kind
+	// A "*." rule also decides if its parent matches.
+	last.settle()
+	path[len(path)-2].settle()
+	if t.opts.prefilter > 0 && kind&exceptionRules == 0 {
+		t.filterAdd(reversedLabels)
+	}
+}
 
-	for _, d := range domains {
-		reversedLabels, err := reverseLabelSlice(d)
-		length := len(reversedLabels)
-		if err != nil {
-			return nil, fmt.Errorf("Failed to build DomainTrie: %v", err)
+// Remove removes a rule previously added to the trie and returns true, or
+// false if the rule is not present. Removing a "*." rule keeps its parent
+// matching if it was also added as an exact rule, and nodes that no longer lead
+// to any rule are pruned.
+func (t *Trie[V]) Remove(rule string) bool {
+	reversedLabels, kind, err := t.parse(rule)
+	if err != nil {
+		return false
+	}
+	path := t.path(reversedLabels, false)
+	if path == nil || path[len(path)-1].rules&kind == 0 {
+		return false
+	}
+	last := path[len(path)-1]
+	last.rules &^= kind
+	if kind&exceptionRules != 0 {
+		t.exceptions--
+	} else if last.rules&^exceptionRules == 0 {
+		var zero V
+		last.value = zero
+	}
+
+	// Removing a "*." rule may leave its parent without a reason to
+	// match, so both ends of the path need their end state recomputed.
+	last.settle()
+	path[len(path)-2].settle()
+	i := len(path) - 1
+	for ; i > 0; i-- {
+		n, parent := path[i], path[i-1]
+		if n.rules != 0 || len(n.others) > 0 {
+			break
 		}
-		// If it was a star, we need to add it both without the wildcard
-		// for the exact match and with "+" for the normal wildcard
-		// match.
-		wasStar := reversedLabels[length-1] == "*"
-		if wasStar {
-			reversedLabels[length-1] = "+"
+		delete(parent.others, headLabel(n.label))
+		if len(parent.others) == 0 {
+			parent.others = nil
 		}
-		addReversedLabelsToTrie(root, reversedLabels)
-		if wasStar {
-			addReversedLabelsToTrie(root, reversedLabels[:length-1])
+		if isPatternChild(n.label) {
+			parent.patterns = removeLabel(parent.patterns, n.label)
+		}
+	}
+	if i > 0 {
+		// The first node kept may be left with a single child.
+		path[i].compact()
+	}
+	return true
+}
+
+// removeLabel returns labels without label. It does not modify labels, which
+// may be shared with a copy of the trie.
+func removeLabel(labels []string, label string) []string {
+	var kept []string
+	for _, l := range labels {
+		if l != label {
+			kept = append(kept, l)
 		}
 	}
+	return kept
+}
 
+// settle recomputes if n is an ending state or excluded by an exception from
+// the rules ending at it and, for label nodes, at its "+" child. Exact rules
+// are more specific than "*." rules and exceptions win over rules of the same
+// kind.
+func (n *node[V]) settle() {
+	if _, _, bounded := bounds(n.label); bounded || n.label == "+" {
+		n.except = n.rules&(exceptChildrenRule|exceptSubtreeRule) != 0
+		n.end = !n.except && n.rules&(childrenRule|subtreeRule) != 0
+		return
+	}
+	var star uint8
+	if wildcard := findNode("+", n.others); wildcard != nil {
+		star = wildcard.rules & (subtreeRule | exceptSubtreeRule)
+	}
+	n.end, n.except = false, false
+	switch {
+	case n.rules&exceptExactRule != 0:
+		n.except = true
+	case n.rules&exactRule != 0:
+		n.end = true
+	case star&exceptSubtreeRule != 0:
+		n.except = true
+	case star&subtreeRule != 0:
+		n.end = true
+	}
+}
+
+// Insert adds a rule to the trie. A "*." rule adds both the "+" wildcard and
+// its exact parent, as `MakeTrie` does.
+func (root *DomainTrie) Insert(rule string) error {
+	return root.Add(rule, struct{}{})
+}
+
+// MakeTrie returns the root of a trie given a slice of domain names,
+// configured with opts. Use WithNormalization to prepare domains received
+// from untrusted or unreliable sources. With WithStrictValidation, every
+// invalid rule is reported in RuleErrors.
+func MakeTrie(domains []string, opts ...Option) (*DomainTrie, error) {
+	root := New(opts...)
+	var errs RuleErrors
+	for i, d := range domains {
+		reversedLabels, kind, err := root.parse(d)
+		switch {
+		case err == nil:
+			root.add(reversedLabels, kind, struct{}{})
+		case root.opts.strict:
+			errs = append(errs, &RuleError{Index: i, Rule: d, Err: err})
+		default:
+			return nil, fmt.Errorf("Failed to build DomainTrie: Failed to add %v: %v", d, err)
+		}
+	}
+	if len(errs) > 0 {
+		return nil, errs
+	}
 	return root, nil
 }
@@ -1,59 +1,13 @@
 package dnstrie
 
 import (
+	"fmt"
+	"math/rand"
 	"reflect"
+	"strings"
 	"testing"
 )
 
-func TestCheckAndRemoveWildcard(t *testing.T) {
-	type testCase struct {
-		domain       string
-		domainParsed string
-		wildcard     string
-	}
-
-	testCases := []testCase{
-		testCase{"*.google.com", "google.com", "*"},
-		testCase{"+.google.com", "google.com", "+"},
-		testCase{"google.com", "google.com", ""},
-		testCase{"foo.*.google.com", "foo.*.google.com", ""},
-		testCase{"foo.+.google.com", "foo.+.google.com", ""},
-		testCase{"*google.com", "*google.com", ""},
-		testCase{"+google.com", "+google.com", ""},
-	}
-
-	for _, tc := range testCases {
-		parsed, wild := checkAndRemoveWildcard(tc.domain)
-		if parsed != tc.domainParsed || wild != tc.wildcard {
-			t.Fatalf("Failed with %+v. Got %v, %v.", tc, parsed, wild)
-		}
-	}
-}
-
-func TestReverseLabelSlice(t *testing.T) {
-	type testCase struct {
-		domain         string
-		reversedLabels []string
-	}
-
-	testCases := []testCase{
-		testCase{"www.google.com", []string{"com", "google", "www"}},
-		testCase{"www.google.co.uk", []string{"uk", "co", "google", "www"}},
-		testCase{"not.a.real.domain.asdashfkjah", []string{"asdashfkjah", "domain", "real", "a", "not"}},
-		testCase{"foo.com.gza.com", []string{"com", "gza", "com", "foo"}},
-		testCase{"com", []string{"com"}},
-		testCase{"", []string{""}},
-		testCase{"*.foo.com", []string{"com", "foo", "*"}},
-	}
-
-	for _, tc := range testCases {
-		reversedLabels, _ := reverseLabelSlice(tc.domain)
-		if !reflect.DeepEqual(reversedLabels, tc.reversedLabels) {
-			t.Fatalf("Failed to reverse labels. Got %+v expected %+v.", reversedLabels, tc.reversedLabels)
-		}
-	}
-}
-
 func TestMakeTrie(t *testing.T) {
 	type testCase struct {
 		domains []string
@@ -61,23 +15,20 @@ func TestMakeTrie(t *testing.T) {
 	}
 
 	testCases := []testCase{
-		{[]string{"www.google.com", "+.google.com"}, &DomainTrie{
+		{[]string{"www.google.com", "+.google.com"}, &DomainTrie{Trie[struct{}]{root: node[struct{}]{
 			label: ".",
-			others: domainTrieSlice{
-				&DomainTrie{
-					label: "com",
-					others: domainTrieSlice{
-						&DomainTrie{
-							label: "google",
-							others: domainTrieSlice{
-								&DomainTrie{"www", domainTrieSlice{}, true},
-								&DomainTrie{"+", domainTrieSlice{}, true},
-							},
-						},
+			others: nodeMap[struct{}]{
+				// "com" has no rules and a single child, so it
+				// shares a node with "google".
+				"com": &node[struct{}]{
+					label: "google.com",
+					others: nodeMap[struct{}]{
+						"www": &node[struct{}]{label: "www", end: true, rules: exactRule},
+						"+":   &node[struct{}]{label: "+", end: true, rules: childrenRule},
 					},
 				},
 			},
-		},
+		}}},
 		},
 	}
 
@@ -124,6 +75,506 @@ func TestMatch(t *testing.T) {
 		if tc.match != actual {
 			t.Fatalf("Failed for %v (got %v expected %v): tree %+v", tc.domain, actual, tc.match, root)
 		}
+		actual = root.MatchBytes([]byte(tc.domain))
+		if tc.match != actual {
+			t.Fatalf("MatchBytes failed for %v (got %v expected %v): tree %+v", tc.domain, actual, tc.match, root)
+		}
+	}
+}
+
+func TestMatchEdgeCases(t *testing.T) {
+	type testCase struct {
+		domain string
+		match  bool
+	}
+	root, err := MakeTrie([]string{"google.com", "+.example.org", "a.b.c.d"})
+	if err != nil {
+		t.Fatalf("Failed to MakeTrie: %v", err)
+	}
+
+	testCases := []testCase{
+		{"", false},
+		{".", false},
+		{"google.com.", false},
+		{".google.com", false},
+		{"google..com", false},
+		{"com", false},
+		{"www.google.com", false},
+		{"x.example.org", true},
+		{".example.org", true},
+		{"example.org", false},
+		{"a.b.c.d", true},
+		{"b.c.d", false},
+	}
+	for _, tc := range testCases {
+		if actual := root.Match(tc.domain); tc.match != actual {
+			t.Fatalf("Failed for %q (got %v expected %v)", tc.domain, actual, tc.match)
+		}
+		if actual := root.MatchBytes([]byte(tc.domain)); tc.match != actual {
+			t.Fatalf("MatchBytes failed for %q (got %v expected %v)", tc.domain, actual, tc.match)
+		}
+	}
+}
+
+func TestMatchDoesNotAllocate(t *testing.T) {
+	root, err := MakeTrie([]string{"+.google.com", "www.google.org", "mail.yahoo.com", "cdn-*.[a-f]?.example.net"})
+	if err != nil {
+		t.Fatalf("Failed to MakeTrie: %v", err)
+	}
+	domains := []string{"www.google.com", "www.google.org", "google.org", "nope.yahoo.com", "not.in.the.trie", "cdn-1.b2.example.net"}
+	buf := []byte("a.deep.sub.domain.of.mail.yahoo.com")
+	allocs := testing.AllocsPerRun(100, func() {
+		for _, d := range domains {
+			root.Match(d)
+		}
+		root.MatchBytes(buf)
+	})
+	if allocs != 0 {
+		t.Fatalf("Match allocated %v times per run, expected 0", allocs)
+	}
+}
+
+func TestLookup(t *testing.T) {
+	type testCase struct {
+		domain string
+		value  string
+		found  bool
+	}
+	trie := NewTrie[string]()
+	rules := [][2]string{
+		{"+.com", "tld"},
+		{"*.google.com", "google"},
+		{"www.google.com", "www"},
+		{"+.mail.google.com", "mail"},
+		{"yahoo.com", "yahoo-exact"},
+		{"*.yahoo.com", "yahoo-star"},
+		{"*.example.org", "example-star"},
+		{"example.org", "example-exact"},
+	}
+	for _, r := range rules {
+		if err := trie.Add(r[0], r[1]); err != nil {
+			t.Fatalf("Failed to Add %v: %v", r[0], err)
+		}
+	}
+
+	testCases := []testCase{
+		{"com", "", false},
+		{"foo.com", "tld", true},
+		{"google.com", "google", true},
+		{"maps.google.com", "google", true},
+		{"www.google.com", "www", true},
+		{"foo.www.google.com", "google", true},
+		{"mail.google.com", "google", true},
+		{"inbox.mail.google.com", "mail", true},
+		{"yahoo.com", "yahoo-exact", true},
+		{"news.yahoo.com", "yahoo-star", true},
+		{"example.org", "example-exact", true},
+		{"www.example.org", "example-star", true},
+		{"org", "", false},
+		{"foo.org", "", false},
+	}
+	for _, tc := range testCases {
+		value, found := trie.Lookup(tc.domain)
+		if value != tc.value || found != tc.found {
+			t.Fatalf("Lookup(%v) got (%q, %v) expected (%q, %v)", tc.domain, value, found, tc.value, tc.found)
+		}
+		if match := trie.Match(tc.domain); match != tc.found {
+			t.Fatalf("Match(%v) got %v expected %v", tc.domain, match, tc.found)
+		}
+	}
+}
+
+func TestMatchRule(t *testing.T) {
+	type testCase struct {
+		domain string
+		rule   string
+		all    []string
+	}
+	root, err := MakeTrie([]string{"+.com", "*.google.com", "www.google.com", "+.mail.google.com", "*.yahoo.com", "+.yahoo.com", "yahoo.com", "example.org"})
+	if err != nil {
+		t.Fatalf("Failed to MakeTrie: %v", err)
+	}
+
+	testCases := []testCase{
+		{"com", "", nil},
+		{"foo.com", "+.com", []string{"+.com"}},
+		{"google.com", "*.google.com", []string{"+.com", "*.google.com"}},
+		{"www.google.com", "www.google.com", []string{"+.com", "*.google.com", "www.google.com"}},
+		{"a.www.google.com", "*.google.com", []string{"+.com", "*.google.com"}},
+		{"inbox.mail.google.com", "+.mail.google.com", []string{"+.com", "*.google.com", "+.mail.google.com"}},
+		{"yahoo.com", "yahoo.com", []string{"+.com", "*.yahoo.com", "yahoo.com"}},
+		{"news.yahoo.com", "+.yahoo.com", []string{"+.com", "*.yahoo.com", "+.yahoo.com"}},
+		{"example.org", "example.org", []string{"example.org"}},
+		{"www.example.org", "", nil},
+	}
+	for _, tc := range testCases {
+		rule, ok := root.MatchRule(tc.domain)
+		if rule != tc.rule || ok != (tc.rule != "") {
+			t.Fatalf("MatchRule(%v) got (%q, %v) expected %q", tc.domain, rule, ok, tc.rule)
+		}
+		if all := root.MatchAll(tc.domain); !reflect.DeepEqual(all, tc.all) {
+			t.Fatalf("MatchAll(%v) got %q expected %q", tc.domain, all, tc.all)
+		}
+	}
+}
+
+func TestInsertRemove(t *testing.T) {
+	root, err := MakeTrie([]string{"*.google.com", "google.com", "www.google.com", "+.yahoo.com"})
+	if err != nil {
+		t.Fatalf("Failed to MakeTrie: %v", err)
+	}
+	if err := root.Insert("*.mail.yahoo.com"); err != nil {
+		t.Fatalf("Failed to Insert: %v", err)
+	}
+
+	type step struct {
+		remove  string
+		removed bool
+		matches map[string]bool
+	}
+	steps := []step{
+		{"", false, map[string]bool{"google.com": true, "maps.google.com": true, "mail.yahoo.com": true, "a.mail.yahoo.com": true}},
+		{"bing.com", false, nil},
+		{"+.google.com", false, nil},
+		{"yahoo.com", false, nil},
+		{"*.google.com", true, map[string]bool{"google.com": true, "maps.google.com": false, "www.google.com": true}},
+		{"*.google.com", false, nil},
+		{"google.com", true, map[string]bool{"google.com": false, "www.google.com": true}},
+		{"+.yahoo.com", true, map[string]bool{"news.yahoo.com": false, "mail.yahoo.com": true, "a.mail.yahoo.com": true}},
+		{"*.mail.yahoo.com", true, map[string]bool{"mail.yahoo.com": false, "a.mail.yahoo.com": false}},
+		{"www.google.com", true, map[string]bool{"www.google.com": false}},
+	}
+	for _, s := range steps {
+		if s.remove != "" {
+			if removed := root.Remove(s.remove); removed != s.removed {
+				t.Fatalf("Remove(%v) got %v expected %v", s.remove, removed, s.removed)
+			}
+		}
+		for domain, match := range s.matches {
+			if actual := root.Match(domain); actual != match {
+				t.Fatalf("After removing %q, Match(%v) got %v expected %v", s.remove, domain, actual, match)
+			}
+		}
+	}
+	if !root.Empty() {
+		t.Fatalf("Removing every rule did not prune the trie: %+v", root)
+	}
+
+	// A "*." rule removed after its exact parent must not leave the
+	// parent matching.
+	root, _ = MakeTrie([]string{"google.com", "*.google.com"})
+	root.Remove("google.com")
+	if !root.Match("google.com") {
+		t.Fatalf("Removing google.com unmatched the parent of *.google.com")
+	}
+	root.Remove("*.google.com")
+	if root.Match("google.com") || !root.Empty() {
+		t.Fatalf("Removing *.google.com left rules behind: %+v", root)
+	}
+}
+
+func TestExceptions(t *testing.T) {
+	type testCase struct {
+		domain string
+		match  bool
+		rule   string
+	}
+	rules := []string{
+		"+.ads.example.com", "!safe.ads.example.com", "!+.clean.ads.example.com",
+		"bad.clean.ads.example.com", "!*.partner.ads.example.com", "*.evil.partner.ads.example.com",
+		"*.google.com", "!google.com", "!*.mail.google.com", "mail.google.com",
+		"yahoo.com", "!*.yahoo.com",
+	}
+	trie := NewTrie[string]()
+	for _, r := range rules {
+		if err := trie.Add(r, r); err != nil {
+			t.Fatalf("Failed to Add %v: %v", r, err)
+		}
+	}
+	root, err := MakeTrie(rules)
+	if err != nil {
+		t.Fatalf("Failed to MakeTrie: %v", err)
+	}
+	compiled := root.Freeze()
+
+	testCases := []testCase{
+		{"ads.example.com", false, ""},
+		{"tracker.ads.example.com", true, "+.ads.example.com"},
+		{"safe.ads.example.com", false, ""},
+		{"www.safe.ads.example.com", true, "+.ads.example.com"},
+		{"clean.ads.example.com", true, "+.ads.example.com"},
+		{"x.clean.ads.example.com", false, ""},
+		{"bad.clean.ads.example.com", true, "bad.clean.ads.example.com"},
+		{"x.bad.clean.ads.example.com", false, ""},
+		{"partner.ads.example.com", false, ""},
+		{"x.partner.ads.example.com", false, ""},
+		{"evil.partner.ads.example.com", true, "*.evil.partner.ads.example.com"},
+		{"x.evil.partner.ads.example.com", true, "*.evil.partner.ads.example.com"},
+		{"google.com", false, ""},
+		{"www.google.com", true, "*.google.com"},
+		{"mail.google.com", true, "mail.google.com"},
+		{"inbox.mail.google.com", false, ""},
+		{"yahoo.com", true, "yahoo.com"},
+		{"news.yahoo.com", false, ""},
+	}
+	for _, tc := range testCases {
+		if actual := root.Match(tc.domain); actual != tc.match {
+			t.Fatalf("Match(%v) got %v expected %v", tc.domain, actual, tc.match)
+		}
+		if actual := root.MatchBytes([]byte(tc.domain)); actual != tc.match {
+			t.Fatalf("MatchBytes(%v) got %v expected %v", tc.domain, actual, tc.match)
+		}
+		if actual := compiled.Match(tc.domain); actual != tc.match {
+			t.Fatalf("CompiledTrie.Match(%v) got %v expected %v", tc.domain, actual, tc.match)
+		}
+		if rule, ok := root.MatchRule(tc.domain); rule != tc.rule || ok != tc.match {
+			t.Fatalf("MatchRule(%v) got (%q, %v) expected %q", tc.domain, rule, ok, tc.rule)
+		}
+		if value, ok := trie.Lookup(tc.domain); value != tc.rule || ok != tc.match {
+			t.Fatalf("Lookup(%v) got (%q, %v) expected %q", tc.domain, value, ok, tc.rule)
+		}
+	}
+
+	all := root.MatchAll("x.clean.ads.example.com")
+	expected := []string{"+.ads.example.com", "!+.clean.ads.example.com"}
+	if !reflect.DeepEqual(all, expected) {
+		t.Fatalf("MatchAll got %q expected %q", all, expected)
+	}
+
+	if !root.Remove("!safe.ads.example.com") || root.Remove("!safe.ads.example.com") {
+		t.Fatalf("Remove did not remove the exception once")
+	}
+	if !root.Match("safe.ads.example.com") {
+		t.Fatalf("Removed exception still applies")
+	}
+	for _, r := range rules {
+		root.Remove(r)
+	}
+	if !root.Empty() || root.exceptions != 0 {
+		t.Fatalf("Removing every rule left %d exceptions: %+v", root.exceptions, root)
+	}
+}
+
+func TestInteriorWildcards(t *testing.T) {
+	type testCase struct {
+		domain string
+		match  bool
+		rule   string
+	}
+	rules := []string{
+		"api.*.corp.example.com", "x.api.internal.corp.example.com", "*.*.example.net",
+		"+.example.org", "www.*.example.org", "a.*.*.d", "!api.dev.corp.example.com",
+	}
+	trie := NewTrie[string]()
+	for _, r := range rules {
+		if err := trie.Add(r, r); err != nil {
+			t.Fatalf("Failed to Add %v: %v", r, err)
+		}
+	}
+	root, err := MakeTrie(rules)
+	if err != nil {
+		t.Fatalf("Failed to MakeTrie: %v", err)
+	}
+	compiled := root.Freeze()
+
+	testCases := []testCase{
+		{"api.x.corp.example.com", true, "api.*.corp.example.com"},
+		{"api.corp.example.com", false, ""},
+		{"api.a.b.corp.example.com", false, ""},
+		{"www.x.corp.example.com", false, ""},
+		{"api.internal.corp.example.com", true, "api.*.corp.example.com"},
+		{"x.api.internal.corp.example.com", true, "x.api.internal.corp.example.com"},
+		{"api.dev.corp.example.com", false, ""},
+		{"api.*.corp.example.com", true, "api.*.corp.example.com"},
+		{"example.net", false, ""},
+		{"a.example.net", true, "*.*.example.net"},
+		{"b.a.example.net", true, "*.*.example.net"},
+		{"www.a.example.org", true, "www.*.example.org"},
+		{"ftp.a.example.org", true, "+.example.org"},
+		{"a.b.c.d", true, "a.*.*.d"},
+		{"a.b.d", false, ""},
+	}
+	for _, tc := range testCases {
+		if actual := root.Match(tc.domain); actual != tc.match {
+			t.Fatalf("Match(%v) got %v expected %v", tc.domain, actual, tc.match)
+		}
+		if actual := root.MatchBytes([]byte(tc.domain)); actual != tc.match {
+			t.Fatalf("MatchBytes(%v) got %v expected %v", tc.domain, actual, tc.match)
+		}
+		if actual := compiled.Match(tc.domain); actual != tc.match {
+			t.Fatalf("CompiledTrie.Match(%v) got %v expected %v", tc.domain, actual, tc.match)
+		}
+		if rule, ok := root.MatchRule(tc.domain); rule != tc.rule || ok != tc.match {
+			t.Fatalf("MatchRule(%v) got (%q, %v) expected %q", tc.domain, rule, ok, tc.rule)
+		}
+		if value, ok := trie.Lookup(tc.domain); value != tc.rule || ok != tc.match {
+			t.Fatalf("Lookup(%v) got (%q, %v) expected %q", tc.domain, value, ok, tc.rule)
+		}
+	}
+
+	all := root.MatchAll("www.a.example.org")
+	expected := []string{"+.example.org", "www.*.example.org"}
+	if !reflect.DeepEqual(all, expected) {
+		t.Fatalf("MatchAll got %q expected %q", all, expected)
+	}
+
+	for _, r := range rules {
+		if !root.Remove(r) {
+			t.Fatalf("Failed to Remove %v", r)
+		}
+	}
+	if !root.Empty() {
+		t.Fatalf("Removing every rule left nodes behind: %+v", root)
+	}
+}
+
+func TestMatchLabel(t *testing.T) {
+	type testCase struct {
+		pattern string
+		label   string
+		match   bool
+	}
+	testCases := []testCase{
+		{"*", "", true},
+		{"*", "anything", true},
+		{"cdn-*", "cdn-123", true},
+		{"cdn-*", "cdn-", true},
+		{"cdn-*", "cdn", false},
+		{"cdn-*", "xcdn-1", false},
+		{"*-edge-*", "a-edge-b-edge-c", true},
+		{"*-edge-*", "a-edge", false},
+		{"x????", "x7f3a", true},
+		{"x????", "x7f3", false},
+		{"x????", "x7f3ab", false},
+		{"x[0-9a-f][0-9a-f]", "x7f", true},
+		{"x[0-9a-f][0-9a-f]", "x7g", false},
+		{"[!a-z]*", "1abc", true},
+		{"[!a-z]*", "abc", false},
+		{"[]]", "]", true},
+		{"[a-]", "-", true},
+		{"a*b*c", "aXbYbZc", true},
+		{"a*b*c", "aXbYbZ", false},
+		{"[a-z", "a", false},
+	}
+	for _, tc := range testCases {
+		if actual := matchLabel(tc.pattern, tc.label); actual != tc.match {
+			t.Fatalf("matchLabel(%q, %q) got %v expected %v", tc.pattern, tc.label, actual, tc.match)
+		}
+		if actual := matchLabel(tc.pattern, []byte(tc.label)); actual != tc.match {
+			t.Fatalf("matchLabel(%q, []byte(%q)) got %v expected %v", tc.pattern, tc.label, actual, tc.match)
+		}
+	}
+}
+
+func TestGlobPatterns(t *testing.T) {
+	rules := []string{
+		"cdn-*.example.net", "cdn-static.example.net", "!cdn-safe.example.net", "x????.bad.biz",
+		"*.host[0-9].example.org", "www.*.[a-c]*.example.com",
+	}
+	root, err := MakeTrie(rules)
+	if err != nil {
+		t.Fatalf("Failed to MakeTrie: %v", err)
+	}
+	compiled := root.Freeze()
+
+	testCases := map[string]string{
+		"cdn-123.example.net":       "cdn-*.example.net",
+		"cdn-static.example.net":    "cdn-static.example.net",
+		"cdn-safe.example.net":      "",
+		"cdn.example.net":           "",
+		"www.cdn-1.example.net":     "",
+		"x7f3a.bad.biz":             "x????.bad.biz",
+		"x7f3.bad.biz":              "",
+		"host7.example.org":         "*.host[0-9].example.org",
+		"a.b.host0.example.org":     "*.host[0-9].example.org",
+		"hostx.example.org":         "",
+		"www.x.alpha.example.com":   "www.*.[a-c]*.example.com",
+		"www.x.delta.example.com":   "",
+		"cdn-*.example.net":         "cdn-*.example.net",
+		"x.cdn-static.example.net":  "",
+		"www.x.c.example.com":       "www.*.[a-c]*.example.com",
+		"mail.x.alpha.example.com":  "",
+		"www.x.y.alpha.example.com": "",
+	}
+	for domain, expected := range testCases {
+		if actual := root.Match(domain); actual != (expected != "") {
+			t.Fatalf("Match(%v) got %v expected %v", domain, actual, expected != "")
+		}
+		if actual := compiled.Match(domain); actual != (expected != "") {
+			t.Fatalf("CompiledTrie.Match(%v) got %v expected %v", domain, actual, expected != "")
+		}
+		if rule, _ := root.MatchRule(domain); rule != expected {
+			t.Fatalf("MatchRule(%v) got %q expected %q", domain, rule, expected)
+		}
+	}
+
+	if _, err := MakeTrie([]string{"cdn-[0-9.example.net"}); err == nil {
+		t.Fatalf("MakeTrie accepted an unterminated character class")
+	}
+}
+
+func TestDepthBoundedWildcards(t *testing.T) {
+	rules := []string{
+		"+2.cdn.example.com", "=1.tracker.example.com", "!+.ads.example.org", "=1.ads.example.org",
+		"+.example.net", "!+2.example.net", "=2.example.net", "deep.a.b.example.net",
+	}
+	root, err := MakeTrie(rules)
+	if err != nil {
+		t.Fatalf("Failed to MakeTrie: %v", err)
+	}
+	compiled := root.Freeze()
+
+	testCases := map[string]string{
+		"cdn.example.com":           "",
+		"a.cdn.example.com":         "+2.cdn.example.com",
+		"b.a.cdn.example.com":       "+2.cdn.example.com",
+		"c.b.a.cdn.example.com":     "",
+		"tracker.example.com":       "",
+		"t1.tracker.example.com":    "=1.tracker.example.com",
+		"x.t1.tracker.example.com":  "",
+		"ads.example.org":           "",
+		"banner.ads.example.org":    "=1.ads.example.org",
+		"x.banner.ads.example.org":  "",
+		"a.example.net":             "",
+		"b.a.example.net":           "=2.example.net",
+		"c.b.a.example.net":         "+.example.net",
+		"deep.a.b.example.net":      "deep.a.b.example.net",
+		"x.x.x.x.x.x.x.example.net": "+.example.net",
+	}
+	for domain, expected := range testCases {
+		if actual := root.Match(domain); actual != (expected != "") {
+			t.Fatalf("Match(%v) got %v expected %v", domain, actual, expected != "")
+		}
+		if actual := root.MatchBytes([]byte(domain)); actual != (expected != "") {
+			t.Fatalf("MatchBytes(%v) got %v expected %v", domain, actual, expected != "")
+		}
+		if actual := compiled.Match(domain); actual != (expected != "") {
+			t.Fatalf("CompiledTrie.Match(%v) got %v expected %v", domain, actual, expected != "")
+		}
+		if rule, _ := root.MatchRule(domain); rule != expected {
+			t.Fatalf("MatchRule(%v) got %q expected %q", domain, rule, expected)
+		}
+	}
+
+	all := root.MatchAll("b.a.example.net")
+	expected := []string{"+.example.net", "!+2.example.net", "=2.example.net"}
+	if !reflect.DeepEqual(all, expected) {
+		t.Fatalf("MatchAll got %q expected %q", all, expected)
+	}
+
+	for _, bad := range []string{"+0.example.com", "=128.example.com", "+99999999999999999999.example.com"} {
+		if _, err := MakeTrie([]string{bad}); err == nil {
+			t.Fatalf("MakeTrie accepted %v", bad)
+		}
+	}
+
+	for _, r := range rules {
+		if !root.Remove(r) {
+			t.Fatalf("Failed to Remove %v", r)
+		}
+	}
+	if !root.Empty() {
+		t.Fatalf("Removing every rule left nodes behind: %+v", root)
 	}
 }
 
@@ -141,3 +592,64 @@ func TestEmpty(t *testing.T) {
 		t.Fatalf("Empty() failed for initialized trie: %+v", root)
 	}
 }
+
+// benchmarkRules returns n deterministic rules spread over a handful of TLDs
+// so the TLD nodes have a very large fan-out, as they do in real blocklists.
+func benchmarkRules(n int) []string {
+	tlds := []string{"com", "net", "org", "info", "biz"}
+	r := rand.New(rand.NewSource(1))
+	rules := make([]string, n)
+	for i := range rules {
+		domain := fmt.Sprintf("d%x.%s", r.Int63(), tlds[i%len(tlds)])
+		switch i % 10 {
+		case 0:
+			rules[i] = "+." + domain
+		case 1:
+			rules[i] = "*." + domain
+		case 2:
+			rules[i] = "www." + domain
+		default:
+			rules[i] = domain
+		}
+	}
+	return rules
+}
+
+func benchmarkMakeTrie(b *testing.B, n int) {
+	rules := benchmarkRules(n)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := MakeTrie(rules); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkMakeTrie1k(b *testing.B)   { benchmarkMakeTrie(b, 1000) }
+func BenchmarkMakeTrie10k(b *testing.B)  { benchmarkMakeTrie(b, 10000) }
+func BenchmarkMakeTrie100k(b *testing.B) { benchmarkMakeTrie(b, 100000) }
+
+func benchmarkMatch(b *testing.B, n int) {
+	rules := benchmarkRules(n)
+	root, err := MakeTrie(rules)
+	if err != nil {
+		b.Fatal(err)
+	}
+	// Half of the queries hit a rule, the other half miss under a busy TLD.
+	queries := make([]string, 1024)
+	for i := range queries {
+		if i%2 == 0 {
+			queries[i] = "foo." + strings.TrimPrefix(strings.TrimPrefix(rules[(i*7919)%n], "+."), "*.")
+		} else {
+			queries[i] = fmt.Sprintf("miss%d.com", i)
+		}
+	}
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		root.Match(queries[i%len(queries)])
+	}
+}
+
+func BenchmarkMatch1k(b *testing.B)   { benchmarkMatch(b, 1000) }
+func BenchmarkMatch10k(b *testing.B)  { benchmarkMatch(b, 10000) }
+func BenchmarkMatch100k(b *testing.B) { benchmarkMatch(b, 100000) }
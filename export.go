@@ -0,0 +1,137 @@
+package dnstrie
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// subtree returns the node of zone, with chains expanded, and the labels from
+// the root to it, or the root for an empty zone. Labels of zone are looked up
+// literally.
+func (t *Trie[V]) subtree(zone string) (*node[V], []string, error) {
+	if zone == "" {
+		return &t.root, nil, nil
+	}
+	labels := strings.Split(zone, ".")
+	path := make([]string, len(labels))
+	curr := &t.root
+	for i, label := range labels {
+		path[len(labels)-1-i] = label
+	}
+	for _, label := range path {
+		if curr = findNode(label, curr.others); curr == nil {
+			return nil, nil, fmt.Errorf("Failed to find zone %v in the trie", zone)
+		}
+		curr = curr.expand()
+	}
+	return curr, path, nil
+}
+
+// nodeRules returns the rules ending at n, whose path from the root is path.
+func (n *node[V]) nodeRules(path []string) []string {
+	var rules []string
+	n.visitRules(path, 0, n.rules, func(d decision[V], _ int) bool {
+		rules = append(rules, d.String())
+		return true
+	})
+	return rules
+}
+
+// WriteDOT writes the subtree of zone, or the whole trie if zone is empty, to
+// w as a Graphviz graph. Ending states are drawn with a double outline,
+// wildcards as diamonds and nodes excluded by an exception in red. Every node
+// is labeled like in String and lists the rules ending at it.
+func (t *Trie[V]) WriteDOT(w io.Writer, zone string) error {
+	top, path, err := t.subtree(zone)
+	if err != nil {
+		return err
+	}
+	bw := bufio.NewWriter(w)
+	fmt.Fprintln(bw, "digraph dnstrie {")
+	fmt.Fprintln(bw, "\tnode [shape=ellipse];")
+	id := 0
+	var write func(n *node[V], path []string, label string) int
+	write = func(n *node[V], path []string, label string) int {
+		self := id
+		id++
+		if rules := n.nodeRules(path); len(rules) > 0 {
+			label += "\n" + strings.Join(rules, "\n")
+		}
+		attrs := []string{"label=" + strconv.Quote(label)}
+		if isWildcard(n.label) {
+			attrs = append(attrs, "shape=diamond")
+		}
+		if n.end {
+			attrs = append(attrs, "peripheries=2")
+		}
+		if n.except {
+			attrs = append(attrs, "color=red", "fontcolor=red")
+		}
+		fmt.Fprintf(bw, "\tn%d [%s];\n", self, strings.Join(attrs, ", "))
+		for _, child := range n.expandedChildren() {
+			childPath := append(path[:len(path):len(path)], child.label)
+			fmt.Fprintf(bw, "\tn%d -> n%d;\n", self, write(child, childPath, child.display()))
+		}
+		return self
+	}
+	label := zone
+	if label == "" {
+		label = top.label
+	}
+	write(top, path, label)
+	fmt.Fprintln(bw, "}")
+	if err := bw.Flush(); err != nil {
+		return fmt.Errorf("Failed to write DOT: %v", err)
+	}
+	return nil
+}
+
+// jsonNode is a node as written by WriteJSON.
+type jsonNode struct {
+	Label     string      `json:"label"`
+	Rules     []string    `json:"rules,omitempty"`
+	End       bool        `json:"end,omitempty"`
+	Exception bool        `json:"exception,omitempty"`
+	Wildcard  bool        `json:"wildcard,omitempty"`
+	Children  []*jsonNode `json:"children,omitempty"`
+}
+
+// WriteJSON writes the subtree of zone, or the whole trie if zone is empty, to
+// w as nested JSON objects. Every object has the label of its node, the rules
+// ending at it, if it is an ending state, excluded by an exception or a
+// wildcard and its children sorted by label. The object of zone is labeled
+// with the whole zone.
+func (t *Trie[V]) WriteJSON(w io.Writer, zone string) error {
+	top, path, err := t.subtree(zone)
+	if err != nil {
+		return err
+	}
+	var convert func(n *node[V], path []string) *jsonNode
+	convert = func(n *node[V], path []string) *jsonNode {
+		j := &jsonNode{
+			Label:     n.label,
+			Rules:     n.nodeRules(path),
+			End:       n.end,
+			Exception: n.except,
+			Wildcard:  isWildcard(n.label),
+		}
+		for _, child := range n.expandedChildren() {
+			j.Children = append(j.Children, convert(child, append(path[:len(path):len(path)], child.label)))
+		}
+		return j
+	}
+	j := convert(top, path)
+	if zone != "" {
+		j.Label = zone
+	}
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(j); err != nil {
+		return fmt.Errorf("Failed to write JSON: %v", err)
+	}
+	return nil
+}
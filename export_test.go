@@ -0,0 +1,73 @@
+package dnstrie
+
+import (
+	"bytes"
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestWriteDOT(t *testing.T) {
+	root, _ := MakeTrie([]string{"*.org", "google.com", "+.mail.google.com", "!safe.google.com"})
+	var buf bytes.Buffer
+	if err := root.WriteDOT(&buf, ""); err != nil {
+		t.Fatalf("WriteDOT failed: %v", err)
+	}
+	dot := buf.String()
+	for _, line := range []string{
+		"digraph dnstrie {",
+		`n0 [label="."];`,
+		`n2 [label="google\ngoogle.com", peripheries=2];`,
+		`n4 [label="+\n+.mail.google.com", shape=diamond, peripheries=2];`,
+		`n5 [label="!safe\n!safe.google.com", color=red, fontcolor=red];`,
+		`n7 [label="*\n*.org", shape=diamond, peripheries=2];`,
+		"n0 -> n6;",
+	} {
+		if !strings.Contains(dot, line) {
+			t.Fatalf("WriteDOT output is missing %q:\n%v", line, dot)
+		}
+	}
+
+	buf.Reset()
+	if err := root.WriteDOT(&buf, "mail.google.com"); err != nil {
+		t.Fatalf("WriteDOT of a zone failed: %v", err)
+	}
+	if !strings.Contains(buf.String(), `n0 [label="mail.google.com"];`) || strings.Contains(buf.String(), "org") {
+		t.Fatalf("WriteDOT did not write only the zone:\n%v", buf.String())
+	}
+	if err := root.WriteDOT(&buf, "yahoo.com"); err == nil {
+		t.Fatalf("WriteDOT of a missing zone did not fail")
+	}
+}
+
+func TestWriteJSON(t *testing.T) {
+	root, _ := MakeTrie([]string{"*.org", "google.com", "+.mail.google.com", "!safe.google.com"})
+	var buf bytes.Buffer
+	if err := root.WriteJSON(&buf, "google.com"); err != nil {
+		t.Fatalf("WriteJSON failed: %v", err)
+	}
+	var actual jsonNode
+	if err := json.Unmarshal(buf.Bytes(), &actual); err != nil {
+		t.Fatalf("WriteJSON wrote invalid JSON: %v", err)
+	}
+	expected := jsonNode{
+		Label: "google.com",
+		Rules: []string{"google.com"},
+		End:   true,
+		Children: []*jsonNode{
+			{Label: "mail", Children: []*jsonNode{
+				{Label: "+", Rules: []string{"+.mail.google.com"}, End: true, Wildcard: true},
+			}},
+			{Label: "safe", Rules: []string{"!safe.google.com"}, Exception: true},
+		},
+	}
+	if !reflect.DeepEqual(actual, expected) {
+		t.Fatalf("WriteJSON got %v", buf.String())
+	}
+
+	buf.Reset()
+	if err := root.WriteJSON(&buf, ""); err != nil || !strings.HasPrefix(buf.String(), "{\n  \"label\": \".\",") {
+		t.Fatalf("WriteJSON of the whole trie failed: %v\n%v", err, buf.String())
+	}
+}
@@ -1,9 +1,16 @@
 module github.com/ynadji/dnstrie
 
-go 1.15
+go 1.19
 
 require (
 	github.com/asaskevich/govalidator v0.0.0-20210307081110-f21760c49a8d
 	github.com/urfave/cli/v2 v2.3.0
 	golang.org/x/net v0.0.0-20220325170049-de3da57026de
 )
+
+require (
+	github.com/cpuguy83/go-md2man/v2 v2.0.0-20190314233015-f79a8a8ca69d // indirect
+	github.com/russross/blackfriday/v2 v2.0.1 // indirect
+	github.com/shurcooL/sanitized_anchor_name v1.0.0 // indirect
+	golang.org/x/text v0.3.7 // indirect
+)
@@ -0,0 +1,118 @@
+package dnstrie
+
+import (
+	"fmt"
+	"os"
+	"unsafe"
+)
+
+// MappedTrie is a CompiledTrie used in place from a file written by
+// `DomainTrie.WriteTo` or `CompiledTrie.WriteTo`. The file is memory-mapped
+// where the platform supports it, so opening it does not copy the trie onto
+// the heap and processes matching against the same file share one copy of it
+// through the page cache. Create one with `dnstrie.Open` and release it with
+// Close.
+type MappedTrie struct {
+	CompiledTrie
+	data   []byte
+	mapped bool
+}
+
+// Open maps the trie file at path and verifies its checksum and structure, as
+// ReadFrom does. Verifying reads the whole file once; use OpenUnverified to
+// skip it for trusted files that are much larger than memory.
+func Open(path string) (*MappedTrie, error) {
+	return open(path, true)
+}
+
+// OpenUnverified is like Open but only checks the header and the size of the
+// file. Matching against a corrupted file may then return wrong results or
+// panic, but never reads outside of the file.
+func OpenUnverified(path string) (*MappedTrie, error) {
+	return open(path, false)
+}
+
+func open(path string, verify bool) (*MappedTrie, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to open trie: %v", err)
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return nil, fmt.Errorf("Failed to open trie: %v", err)
+	}
+	if info.Size() < headerSize || int64(int(info.Size())) != info.Size() {
+		return nil, fmt.Errorf("Failed to open trie %s: %w: bad file size %d", path, ErrInvalidTrie, info.Size())
+	}
+	data, mapped, err := mapFile(f, int(info.Size()))
+	if err != nil {
+		return nil, fmt.Errorf("Failed to map trie %s: %v", path, err)
+	}
+
+	m := &MappedTrie{data: data, mapped: mapped}
+	if err := m.load(verify); err != nil {
+		m.Close()
+		return nil, fmt.Errorf("Failed to open trie %s: %w", path, err)
+	}
+	return m, nil
+}
+
+func (m *MappedTrie) load(verify bool) error {
+	h, err := unmarshalHeader(m.data)
+	if err != nil {
+		return err
+	}
+	body := m.data[headerSize:]
+	if err := h.checkBody(body, verify); err != nil {
+		return err
+	}
+	if littleEndian {
+		m.CompiledTrie = *h.compiled(body, viewBitset, viewUint32s, func(b []byte) []byte { return b })
+	} else {
+		m.CompiledTrie = *h.compiled(body, decodeBitset, decodeUint32s, func(b []byte) []byte { return b })
+	}
+	if verify {
+		return m.validate()
+	}
+	// Even unverified tries need the few invariants Match relies on to
+	// index its arrays.
+	if len(m.nodeLabels) == 0 || len(m.labelOffsets) == 0 || len(m.index)&(len(m.index)-1) != 0 {
+		return fmt.Errorf("%w: bad header", ErrInvalidTrie)
+	}
+	return nil
+}
+
+// Close unmaps the file. The trie is empty afterwards, and it must not be
+// used concurrently with Close.
+func (m *MappedTrie) Close() error {
+	m.CompiledTrie = CompiledTrie{}
+	data := m.data
+	m.data = nil
+	if data == nil || !m.mapped {
+		return nil
+	}
+	return unmapFile(data)
+}
+
+var littleEndian = func() bool {
+	x := uint16(1)
+	return *(*byte)(unsafe.Pointer(&x)) == 1
+}()
+
+// viewBitset and viewUint32s reinterpret little endian file data in place.
+// Sections are 8 byte aligned within the file and the file is mapped at a page
+// boundary, so the conversions are aligned.
+func viewBitset(b []byte) bitset {
+	if len(b) == 0 {
+		return nil
+	}
+	return unsafe.Slice((*uint64)(unsafe.Pointer(&b[0])), len(b)/8)
+}
+
+func viewUint32s(b []byte) []uint32 {
+	if len(b) == 0 {
+		return nil
+	}
+	return unsafe.Slice((*uint32)(unsafe.Pointer(&b[0])), len(b)/4)
+}
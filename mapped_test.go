@@ -0,0 +1,128 @@
+package dnstrie
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func writeTrieFile(t testing.TB, root *DomainTrie) string {
+	path := filepath.Join(t.TempDir(), "rules.trie")
+	f, err := os.Create(path)
+	if err != nil {
+		t.Fatalf("Failed to create trie file: %v", err)
+	}
+	if _, err := root.WriteTo(f); err != nil {
+		t.Fatalf("Failed to write trie file: %v", err)
+	}
+	if err := f.Close(); err != nil {
+		t.Fatalf("Failed to write trie file: %v", err)
+	}
+	return path
+}
+
+func TestOpen(t *testing.T) {
+	rules := append([]string{"*.google.com", "+.biz", "www.yahoo.com"}, benchmarkRules(1000)...)
+	root, _ := MakeTrie(rules)
+	path := writeTrieFile(t, root)
+
+	for _, open := range []func(string) (*MappedTrie, error){Open, OpenUnverified} {
+		m, err := open(path)
+		if err != nil {
+			t.Fatalf("Failed to open trie: %v", err)
+		}
+		domains := append([]string{"google.com", "a.google.com", "biz", "a.biz", "www.yahoo.com", "yahoo.com", ""}, rules...)
+		for _, d := range domains {
+			if m.Match(d) != root.Match(d) || m.MatchBytes([]byte(d)) != root.Match(d) {
+				t.Fatalf("Match(%q) differs for the mapped trie", d)
+			}
+		}
+		allocs := testing.AllocsPerRun(100, func() {
+			m.Match("www.yahoo.com")
+			m.Match("not.in.the.trie")
+		})
+		if allocs != 0 {
+			t.Fatalf("Match allocated %v times per run, expected 0", allocs)
+		}
+		if err := m.Close(); err != nil {
+			t.Fatalf("Failed to close trie: %v", err)
+		}
+		if m.Match("a.google.com") || !m.Empty() {
+			t.Fatalf("Closed trie still matches")
+		}
+		if err := m.Close(); err != nil {
+			t.Fatalf("Second Close failed: %v", err)
+		}
+	}
+}
+
+func TestOpenRejectsCorruptFiles(t *testing.T) {
+	root, _ := MakeTrie([]string{"*.google.com", "www.yahoo.com"})
+	data, _ := root.MarshalBinary()
+	dir := t.TempDir()
+	write := func(data []byte) string {
+		path := filepath.Join(dir, "corrupt.trie")
+		if err := os.WriteFile(path, data, 0644); err != nil {
+			t.Fatalf("Failed to write trie file: %v", err)
+		}
+		return path
+	}
+
+	if _, err := Open(filepath.Join(dir, "missing.trie")); err == nil {
+		t.Fatalf("Opened a missing file")
+	}
+	if _, err := Open(write(data[:headerSize-1])); !errors.Is(err, ErrInvalidTrie) {
+		t.Fatalf("Opened a truncated file: %v", err)
+	}
+	if _, err := Open(write(data[:len(data)-8])); !errors.Is(err, ErrInvalidTrie) {
+		t.Fatalf("Opened a truncated file: %v", err)
+	}
+
+	// Damage the last label byte, which only the checksum catches.
+	corrupted := append([]byte(nil), data...)
+	h, _ := unmarshalHeader(corrupted)
+	sections, _ := h.sections()
+	corrupted[headerSize+int(sections[labelsSection].offset)] ^= 0x20
+	path := write(corrupted)
+	if _, err := Open(path); !errors.Is(err, ErrInvalidTrie) {
+		t.Fatalf("Opened a corrupted file: %v", err)
+	}
+	m, err := OpenUnverified(path)
+	if err != nil {
+		t.Fatalf("OpenUnverified rejected a file with a valid header: %v", err)
+	}
+	m.Close()
+
+	corrupted = append([]byte(nil), data...)
+	corrupted[0] = 'X'
+	if _, err := OpenUnverified(write(corrupted)); !errors.Is(err, ErrInvalidTrie) {
+		t.Fatalf("OpenUnverified accepted bad magic bytes: %v", err)
+	}
+}
+
+func BenchmarkOpen100k(b *testing.B) {
+	root, _ := MakeTrie(benchmarkRules(100000))
+	path := writeTrieFile(b, root)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		m, err := Open(path)
+		if err != nil {
+			b.Fatal(err)
+		}
+		m.Close()
+	}
+}
+
+func BenchmarkOpenUnverified100k(b *testing.B) {
+	root, _ := MakeTrie(benchmarkRules(100000))
+	path := writeTrieFile(b, root)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		m, err := OpenUnverified(path)
+		if err != nil {
+			b.Fatal(err)
+		}
+		m.Close()
+	}
+}
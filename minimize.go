@@ -0,0 +1,116 @@
+package dnstrie
+
+// Redundancy is a rule removed by Minimize and the rule that already matched
+// every name it matches.
+type Redundancy struct {
+	Rule      string
+	CoveredBy string
+}
+
+// Minimize removes the rules that cannot change what matches the trie because
+// a "+." or "*." rule of an enclosing zone already matches every name they
+// match, e.g., "x.tracker.com" and "+.x.tracker.com" next to "+.tracker.com",
+// and prunes the nodes left without rules. Rules are only dropped when no
+// exception could be decided differently without them. It returns the
+// removed rules with the outermost rule covering each.
+func (root *DomainTrie) Minimize() []Redundancy {
+	var redundant []Redundancy
+	root.root.minimize(nil, "", &redundant)
+	for _, r := range redundant {
+		root.Remove(r.Rule)
+	}
+	return redundant
+}
+
+// minimize appends the redundant rules ending at n and below it to redundant.
+// path holds the labels from the root to n and cover is the rule matching
+// every name below n, or "" if there is none.
+func (n *node[V]) minimize(path []string, cover string, redundant *[]Redundancy) {
+	zone := make([]string, len(path))
+	for i, label := range path {
+		zone[len(path)-1-i] = label
+	}
+	add := func(node *node[V], kind uint8, labels []string, coveredBy string) {
+		d := decision[V]{node: node, kind: kind, labels: labels}
+		*redundant = append(*redundant, Redundancy{d.String(), coveredBy})
+	}
+	wildcard := findNode("+", n.others)
+	// Exception wildcards decide names below n before the rules of the
+	// enclosing zones, so no rule of n is redundant next to them.
+	positive := !n.exceptWildcards()
+	if positive && cover != "" && n.rules&exactRule != 0 {
+		add(n, exactRule, zone, cover)
+	}
+	switch {
+	case !positive:
+		cover = ""
+	case cover != "":
+		n.wildcardRules(func(w *node[V], kind uint8) {
+			add(w, kind, zone, cover)
+		})
+	case wildcard != nil && wildcard.end:
+		kind := childrenRule
+		if wildcard.rules&subtreeRule != 0 {
+			kind = subtreeRule
+		}
+		d := decision[V]{node: wildcard, kind: kind, labels: zone}
+		cover = d.String()
+		if kind == subtreeRule && n.rules&(exactRule|exceptExactRule) == exactRule {
+			add(n, exactRule, zone, cover)
+		}
+		n.wildcardRules(func(w *node[V], kind uint8) {
+			if w != wildcard || kind != d.kind {
+				add(w, kind, zone, cover)
+			}
+		})
+	}
+	for _, pattern := range n.patterns {
+		// A literal label is matched by the exception of a pattern
+		// sibling once its own rules are gone.
+		if _, _, bounded := bounds(pattern); !bounded && n.others[pattern].hasExceptions() {
+			cover = ""
+		}
+	}
+	for _, child := range n.sortedChildren() {
+		child.minimize(append(path[:len(path):len(path)], child.label), cover, redundant)
+	}
+}
+
+// exceptWildcards returns true if n has a "+" child or depth-bounded wildcard
+// that is an exception.
+func (n *node[V]) exceptWildcards() bool {
+	for _, child := range n.others {
+		if isWildcard(child.label) && child.except {
+			return true
+		}
+	}
+	return false
+}
+
+// wildcardRules calls fn for each positive rule of the "+" child and the
+// depth-bounded wildcards of n, ordered by label.
+func (n *node[V]) wildcardRules(fn func(w *node[V], kind uint8)) {
+	for _, child := range n.sortedChildren() {
+		if !isWildcard(child.label) {
+			continue
+		}
+		for _, kind := range []uint8{childrenRule, subtreeRule} {
+			if child.rules&kind != 0 {
+				fn(child, kind)
+			}
+		}
+	}
+}
+
+// hasExceptions returns true if an exception ends at n or below it.
+func (n *node[V]) hasExceptions() bool {
+	if n.rules&exceptionRules != 0 {
+		return true
+	}
+	for _, child := range n.others {
+		if child.hasExceptions() {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,66 @@
+package dnstrie
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestMinimize(t *testing.T) {
+	type testCase struct {
+		rules     []string
+		redundant []Redundancy
+		kept      []string
+	}
+	testCases := []testCase{
+		{
+			[]string{"+.tracker.com", "x.tracker.com", "y.tracker.com", "+.z.tracker.com", "tracker.com"},
+			[]Redundancy{{"x.tracker.com", "+.tracker.com"}, {"y.tracker.com", "+.tracker.com"}, {"+.z.tracker.com", "+.tracker.com"}},
+			[]string{"*.tracker.com"},
+		},
+		{
+			[]string{"*.tracker.com", "tracker.com", "+2.tracker.com", "a.b.tracker.com"},
+			[]Redundancy{{"tracker.com", "*.tracker.com"}, {"+2.tracker.com", "*.tracker.com"}, {"a.b.tracker.com", "*.tracker.com"}},
+			[]string{"*.tracker.com"},
+		},
+		{
+			[]string{"+.com", "+.tracker.com", "x.tracker.com"},
+			[]Redundancy{{"+.tracker.com", "+.com"}, {"x.tracker.com", "+.com"}},
+			[]string{"+.com"},
+		},
+		{
+			// Exceptions keep the rules that decide against them.
+			[]string{"+.ads.com", "!x.ads.com", "a.x.ads.com", "!+.y.ads.com", "a.y.ads.com", "!=1.z.ads.com", "+.z.ads.com"},
+			[]Redundancy{{"a.x.ads.com", "+.ads.com"}},
+			[]string{"+.ads.com", "!x.ads.com", "!+.y.ads.com", "a.y.ads.com", "!=1.z.ads.com", "+.z.ads.com"},
+		},
+		{
+			[]string{"+.cdn.net", "!cdn-*.cdn.net", "cdn-1.cdn.net", "x.cdn.net"},
+			nil,
+			[]string{"!cdn-*.cdn.net", "+.cdn.net", "cdn-1.cdn.net", "x.cdn.net"},
+		},
+		{
+			[]string{"+.+.weird.net", "a.b.weird.net"},
+			nil,
+			[]string{"+.+.weird.net", "a.b.weird.net"},
+		},
+	}
+	for _, tc := range testCases {
+		trie, err := MakeTrie(tc.rules)
+		if err != nil {
+			t.Fatal(err)
+		}
+		original, _ := MakeTrie(tc.rules)
+		redundant := trie.Minimize()
+		if !reflect.DeepEqual(redundant, tc.redundant) {
+			t.Errorf("Minimize(%v) got %v expected %v", tc.rules, redundant, tc.redundant)
+		}
+		if rules := trie.Rules(); !reflect.DeepEqual(rules, tc.kept) {
+			t.Errorf("Minimize(%v) kept %v expected %v", tc.rules, rules, tc.kept)
+		}
+		for _, name := range []string{"tracker.com", "x.tracker.com", "a.z.tracker.com", "a.b.tracker.com", "com", "x.ads.com", "a.x.ads.com", "b.y.ads.com", "a.y.ads.com", "a.z.ads.com", "a.b.z.ads.com", "cdn-1.cdn.net", "cdn-2.cdn.net", "x.cdn.net", "a.b.weird.net"} {
+			if actual, expected := trie.Match(name), original.Match(name); actual != expected {
+				t.Errorf("Minimize(%v) changed %v to %v", tc.rules, name, actual)
+			}
+		}
+	}
+}
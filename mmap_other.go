@@ -0,0 +1,25 @@
+//go:build !unix
+
+package dnstrie
+
+import (
+	"io"
+	"os"
+	"unsafe"
+)
+
+// mapFile reads the file into memory on platforms without mmap support. The
+// result is allocated as uint64s so the sections are aligned as they would be
+// in a mapping.
+func mapFile(f *os.File, size int) ([]byte, bool, error) {
+	words := make([]uint64, (size+7)/8)
+	data := unsafe.Slice((*byte)(unsafe.Pointer(&words[0])), size)
+	if _, err := io.ReadFull(f, data); err != nil {
+		return nil, false, err
+	}
+	return data, false, nil
+}
+
+func unmapFile(data []byte) error {
+	return nil
+}
@@ -0,0 +1,124 @@
+package dnstrie
+
+import (
+	"strings"
+
+	"github.com/ynadji/dnstrie/dns"
+)
+
+// Option configures how a trie prepares the rules added to it and the names
+// matched against it. See New.
+type Option func(*options)
+
+type options struct {
+	normalize   bool
+	trailingDot bool
+	strict      bool
+	workers     int
+	prefilter   float64
+}
+
+// WithNormalization normalizes rules and names like dns.Normalize: surrounding
+// whitespace is trimmed, letters are lowercased and unicode labels are
+// converted to punycode, so "Google.COM" and "bücher.de" match the rules
+// "google.com" and "xn--bcher-kva.de". Names that are already normalized are
+// matched without allocating.
+func WithNormalization() Option {
+	return func(o *options) {
+		o.normalize = true
+	}
+}
+
+// WithTrailingDotTolerance ignores a trailing "." on rules and names, so the
+// fully qualified "google.com." matches "google.com".
+func WithTrailingDotTolerance() Option {
+	return func(o *options) {
+		o.trailingDot = true
+	}
+}
+
+// WithStrictValidation rejects rules that fail Rule.Validate, after any
+// normalization. MakeTrie returns RuleErrors listing every rejected rule.
+//
+// Without it, rules are added as written, but rules that cannot be parsed are
+// still rejected and the first of them stops MakeTrie and BuildFromReader:
+// wildcard depths outside 1 to 127, e.g., "+0.com" and "+128.com", patterns
+// with an unterminated character class, e.g., "[a.com", and, with
+// WithNormalization, labels that cannot be converted to punycode.
+func WithStrictValidation() Option {
+	return func(o *options) {
+		o.strict = true
+	}
+}
+
+// New returns an empty DomainTrie configured with opts.
+func New(opts ...Option) *DomainTrie {
+	return &DomainTrie{*NewTrie[struct{}](opts...)}
+}
+
+// parseRule is like ParseRule but prepares rule as configured by o first and
+// validates it in strict mode.
+func (o *options) parseRule(rule string) (Rule, error) {
+	if o.normalize {
+		rule = strings.ToLower(strings.TrimSpace(rule))
+	}
+	if o.trailingDot {
+		rule = trimTrailingDot(rule)
+	}
+	r, err := ParseRule(rule)
+	if err != nil {
+		return Rule{}, err
+	}
+	if o.normalize {
+		for i, label := range r.Labels {
+			if !normalized(label) {
+				if r.Labels[i], err = dns.Normalize(label); err != nil {
+					return Rule{}, err
+				}
+			}
+		}
+	}
+	if o.strict {
+		if err := r.Validate(); err != nil {
+			return Rule{}, err
+		}
+	}
+	return r, nil
+}
+
+// name prepares domain for matching as configured by o.
+func (o *options) name(domain string) string {
+	if o.normalize && !normalized(domain) {
+		// Names that cannot be converted are matched as they are.
+		if n, err := dns.Normalize(domain); err == nil {
+			domain = n
+		}
+	}
+	if o.trailingDot {
+		domain = trimTrailingDot(domain)
+	}
+	return domain
+}
+
+// normalized returns true if dns.Normalize would not change domain: it is
+// ASCII without upper case letters or surrounding whitespace.
+func normalized[S string | []byte](domain S) bool {
+	for i := 0; i < len(domain); i++ {
+		if c := domain[i]; c >= 0x80 || 'A' <= c && c <= 'Z' {
+			return false
+		}
+	}
+	return len(domain) == 0 || !isSpace(domain[0]) && !isSpace(domain[len(domain)-1])
+}
+
+func isSpace(c byte) bool {
+	return c == ' ' || '\t' <= c && c <= '\r'
+}
+
+// trimTrailingDot removes a trailing "." from domain unless it is the root.
+func trimTrailingDot[S string | []byte](domain S) S {
+	if len(domain) > 1 && domain[len(domain)-1] == '.' {
+		return domain[:len(domain)-1]
+	}
+	return domain
+}
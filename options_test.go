@@ -0,0 +1,108 @@
+package dnstrie
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+)
+
+func TestWithNormalization(t *testing.T) {
+	root := New(WithNormalization())
+	for _, rule := range []string{"Google.COM", " *.Bücher.DE ", "!Safe.Google.com"} {
+		if err := root.Insert(rule); err != nil {
+			t.Fatalf("Failed to Insert %q: %v", rule, err)
+		}
+	}
+	for _, domain := range []string{"google.com", "GOOGLE.com", " google.com\n", "www.xn--bcher-kva.de", "WWW.BÜCHER.de", "bücher.de"} {
+		if !root.Match(domain) || !root.MatchBytes([]byte(domain)) {
+			t.Fatalf("Match(%q) failed", domain)
+		}
+	}
+	if root.Match("SAFE.google.com") || root.Match("www.google.com") {
+		t.Fatalf("Match normalized into a match it should not have")
+	}
+	if rule, _ := root.MatchRule("WWW.Bücher.de"); rule != "*.xn--bcher-kva.de" {
+		t.Fatalf("MatchRule got %q expected *.xn--bcher-kva.de", rule)
+	}
+	if !root.Remove("GOOGLE.com") || root.Match("google.com") {
+		t.Fatalf("Remove did not normalize the rule")
+	}
+
+	plain, _ := MakeTrie([]string{"google.com"})
+	if plain.Match("GOOGLE.com") {
+		t.Fatalf("Match normalized without WithNormalization")
+	}
+
+	allocs := testing.AllocsPerRun(100, func() {
+		root.Match("www.xn--bcher-kva.de")
+		root.MatchBytes([]byte("nope.example.com"))
+	})
+	if allocs != 0 {
+		t.Fatalf("Match of normalized names allocated %v times per run, expected 0", allocs)
+	}
+}
+
+func TestWithTrailingDotTolerance(t *testing.T) {
+	root, err := MakeTrie([]string{"example.com.", "+.example.org"}, WithTrailingDotTolerance())
+	if err != nil {
+		t.Fatalf("Failed to MakeTrie: %v", err)
+	}
+	for _, domain := range []string{"example.com", "example.com.", "www.example.org."} {
+		if !root.Match(domain) || !root.MatchBytes([]byte(domain)) {
+			t.Fatalf("Match(%q) failed", domain)
+		}
+	}
+	if root.Match("example.com..") || root.Match("example.org.") {
+		t.Fatalf("Match ignored more than a trailing dot")
+	}
+}
+
+func TestWithStrictValidation(t *testing.T) {
+	root := New(WithStrictValidation(), WithNormalization())
+	if err := root.Insert("a..b"); err == nil {
+		t.Fatalf("Insert accepted an empty label")
+	}
+	if err := root.Insert("Bücher.DE"); err != nil {
+		t.Fatalf("Insert rejected a rule valid after normalization: %v", err)
+	}
+
+	_, err := MakeTrie([]string{"ok.com", "bad..com", "also bad.com"}, WithStrictValidation())
+	var errs RuleErrors
+	if !errors.As(err, &errs) || len(errs) != 2 || errs[0].Index != 1 || errs[1].Index != 2 {
+		t.Fatalf("MakeTrie did not report every invalid rule: %v", err)
+	}
+
+	// Without strict validation only rules that cannot be parsed fail.
+	if _, err := MakeTrie([]string{"bad..com", "also bad.com"}); err != nil {
+		t.Fatalf("MakeTrie rejected a malformed rule: %v", err)
+	}
+	for _, rule := range []string{"+0.com", "+128.com", "[a.com"} {
+		if _, err := MakeTrie([]string{"ok.com", rule}); err == nil {
+			t.Fatalf("MakeTrie accepted %v", rule)
+		}
+	}
+}
+
+func TestOptionsAreKept(t *testing.T) {
+	root, _ := MakeTrie([]string{"google.com"}, WithNormalization(), WithTrailingDotTolerance())
+	compiled := root.Freeze()
+	if !compiled.Match("Google.COM.") || !compiled.MatchBytes([]byte("GOOGLE.com")) {
+		t.Fatalf("Freeze did not keep the options")
+	}
+	var buf bytes.Buffer
+	if _, err := root.WriteTo(&buf); err != nil {
+		t.Fatalf("WriteTo failed: %v", err)
+	}
+	var read DomainTrie
+	if _, err := read.ReadFrom(&buf); err != nil {
+		t.Fatalf("ReadFrom failed: %v", err)
+	}
+	if !read.Match("Google.COM.") {
+		t.Fatalf("Serialization did not keep the options")
+	}
+	s := NewSyncTrie(root)
+	s.Insert("Yahoo.com")
+	if !s.Match("YAHOO.com.") || !s.Match("google.COM") {
+		t.Fatalf("SyncTrie did not keep the options")
+	}
+}
@@ -0,0 +1,214 @@
+package dnstrie
+
+import (
+	"math"
+	"sync/atomic"
+)
+
+// WithPrefilter keeps a Bloom filter of the zones the positive rules of the
+// trie are anchored at, e.g., "example.com" for "example.com",
+// "+.example.com" and "api.*.example.com", next to the trie. Match, MatchBytes,
+// Lookup and MatchRule first look up every suffix of the name in the filter
+// and only walk the trie if one of them may be a zone of a rule, so names
+// matching nothing are mostly rejected after a few hash probes. The filter
+// never rejects a name a rule matches. falsePositiveRate is the share of zones
+// that are not in the filter but still pass it once it is full, e.g., 0.01.
+// The filter is built with room for twice the rules and a power of two bits,
+// and rebuilt the same way once full, so at 0.01 it uses about 10 to 40 bits
+// per rule depending on how full it is, typically 16 to 26. Removed rules stay
+// in the filter until it is rebuilt. A rule whose first label is a wildcard or
+// pattern, e.g., "+" or "*-cdn", makes the filter pass every name. Freeze
+// copies the filter, but it is not written by WriteTo.
+func WithPrefilter(falsePositiveRate float64) Option {
+	return func(o *options) {
+		o.prefilter = falsePositiveRate
+	}
+}
+
+// prefilter is a Bloom filter of hashed zones. Bits are only ever set, with
+// atomic operations, so a filter can be shared by the versions of a SyncTrie:
+// an older version sees zones added after it as false positives at worst.
+type prefilter struct {
+	bits   []uint64
+	mask   uint64
+	hashes int
+	// keys and capacity are only used when adding zones.
+	keys, capacity int
+	// all is true if a rule may match names with any suffix.
+	all atomic.Bool
+}
+
+// minPrefilterCapacity is the least number of zones a filter is sized for.
+const minPrefilterCapacity = 1024
+
+// newPrefilter returns a filter for capacity zones at the given false
+// positive rate.
+func newPrefilter(capacity int, falsePositiveRate float64) *prefilter {
+	if capacity < minPrefilterCapacity {
+		capacity = minPrefilterCapacity
+	}
+	if falsePositiveRate >= 1 {
+		falsePositiveRate = 0.5
+	}
+	bitsPerKey := -math.Log(falsePositiveRate) / (math.Ln2 * math.Ln2)
+	hashes := int(math.Round(bitsPerKey * math.Ln2))
+	switch {
+	case hashes < 1:
+		hashes = 1
+	case hashes > 16:
+		hashes = 16
+	}
+	size := uint64(64)
+	for float64(size) < bitsPerKey*float64(capacity) {
+		size *= 2
+	}
+	return &prefilter{bits: make([]uint64, size/64), mask: size - 1, hashes: hashes, capacity: capacity}
+}
+
+const (
+	fnvOffset = 14695981039346656037
+	fnvPrime  = 1099511628211
+)
+
+// zoneHash returns the FNV-1a hash of the zone of reversedLabels, over its
+// bytes from last to first so that the hashes of every suffix of a name are
+// found in a single pass.
+func zoneHash(reversedLabels []string) uint64 {
+	h := uint64(fnvOffset)
+	for i, label := range reversedLabels {
+		if i > 0 {
+			h = (h ^ '.') * fnvPrime
+		}
+		for j := len(label) - 1; j >= 0; j-- {
+			h = (h ^ uint64(label[j])) * fnvPrime
+		}
+	}
+	return h
+}
+
+// probes returns the first bit and the step between the bits of hash h.
+func probes(h uint64) (uint64, uint64) {
+	// The finalizer of MurmurHash3 spreads FNV's weak low bits.
+	h ^= h >> 33
+	h *= 0xff51afd7ed558ccd
+	h ^= h >> 33
+	h *= 0xc4ceb9fe1a85ec53
+	h ^= h >> 33
+	return h, h>>32 | 1
+}
+
+// add adds the zone of reversedLabels to f.
+func (f *prefilter) add(reversedLabels []string) {
+	if len(reversedLabels) == 0 {
+		f.keys++
+		f.all.Store(true)
+		return
+	}
+	f.addHash(zoneHash(reversedLabels))
+}
+
+// addHash adds the zone hashed to h to f.
+func (f *prefilter) addHash(h uint64) {
+	f.keys++
+	bit, step := probes(h)
+	for i := 0; i < f.hashes; i++ {
+		word, mask := &f.bits[(bit&f.mask)>>6], uint64(1)<<(bit&63)
+		for {
+			old := atomic.LoadUint64(word)
+			if old&mask != 0 || atomic.CompareAndSwapUint64(word, old, old|mask) {
+				break
+			}
+		}
+		bit += step
+	}
+}
+
+// has returns true if the zone hashed to h may be in f.
+func (f *prefilter) has(h uint64) bool {
+	bit, step := probes(h)
+	for i := 0; i < f.hashes; i++ {
+		if atomic.LoadUint64(&f.bits[(bit&f.mask)>>6])&(1<<(bit&63)) == 0 {
+			return false
+		}
+		bit += step
+	}
+	return true
+}
+
+// mayMatch returns false if no rule can match domain because none of its
+// suffixes is a zone in f.
+func mayMatch[S string | []byte](f *prefilter, domain S) bool {
+	if f.all.Load() {
+		return true
+	}
+	h := uint64(fnvOffset)
+	for i := len(domain) - 1; i >= 0; i-- {
+		h = (h ^ uint64(domain[i])) * fnvPrime
+		if (i == 0 || domain[i-1] == '.') && f.has(h) {
+			return true
+		}
+	}
+	return false
+}
+
+// clone returns a copy of f, or nil if f is nil.
+func (f *prefilter) clone() *prefilter {
+	if f == nil {
+		return nil
+	}
+	c := &prefilter{bits: make([]uint64, len(f.bits)), mask: f.mask, hashes: f.hashes, keys: f.keys, capacity: f.capacity}
+	for i := range f.bits {
+		c.bits[i] = atomic.LoadUint64(&f.bits[i])
+	}
+	c.all.Store(f.all.Load())
+	return c
+}
+
+// anchor returns the zone a positive rule ending at reversedLabels is anchored
+// at: its labels up to the first wildcard or pattern. Every name the rule
+// matches ends with it.
+func anchor(reversedLabels []string) []string {
+	for i, label := range reversedLabels {
+		if !isLiteral(label) {
+			return reversedLabels[:i]
+		}
+	}
+	return reversedLabels
+}
+
+// filterAdd adds the anchor of a positive rule ending at reversedLabels to the
+// filter, building a larger one from every rule of the trie once it is full.
+func (t *Trie[V]) filterAdd(reversedLabels []string) {
+	if t.filter == nil || t.filter.keys >= t.filter.capacity {
+		t.buildFilter()
+		return
+	}
+	t.filter.add(anchor(reversedLabels))
+}
+
+// buildFilter replaces the filter with one of the anchors of every positive
+// rule of the trie, with room for as many more.
+func (t *Trie[V]) buildFilter() {
+	var hashes []uint64
+	all := false
+	var visit func(n *node[V], path []string)
+	visit = func(n *node[V], path []string) {
+		if n.rules&^exceptionRules != 0 {
+			if a := anchor(path); len(a) > 0 {
+				hashes = append(hashes, zoneHash(a))
+			} else {
+				all = true
+			}
+		}
+		for _, child := range n.others {
+			visit(child, append(path[:len(path):len(path)], child.label))
+		}
+	}
+	visit(&t.root, nil)
+	f := newPrefilter(2*len(hashes), t.opts.prefilter)
+	for _, h := range hashes {
+		f.addHash(h)
+	}
+	f.all.Store(all)
+	t.filter = f
+}
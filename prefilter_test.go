@@ -0,0 +1,168 @@
+package dnstrie
+
+import (
+	"fmt"
+	"math/rand"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// TestPrefilterAgrees checks that the prefilter never changes the result of a
+// lookup, whatever the rules.
+func TestPrefilterAgrees(t *testing.T) {
+	r := rand.New(rand.NewSource(1))
+	extra := [][]string{
+		nil,
+		{"*nadji.us", "api.*.example.com", "=2.net"},
+		{"+"},
+		{"*"},
+		{"ads*"},
+	}
+	for _, more := range extra {
+		rules := append(randomRules(r, 300), more...)
+		var names []string
+		for _, rule := range rules {
+			parsed, _ := ParseRule(rule)
+			name := strings.Join(parsed.Labels, ".")
+			names = append(names, name, "x."+name, "ads."+name, "api.x."+name)
+		}
+		names = append(names, "nadji.us", "onizuka.nadji.us", "api.www.example.com", "ads.org", "org", "")
+		expected, err := MakeTrie(rules)
+		if err != nil {
+			t.Fatal(err)
+		}
+		filtered, err := MakeTrie(rules, WithPrefilter(0.01))
+		if err != nil {
+			t.Fatal(err)
+		}
+		compiled := filtered.Freeze()
+		thawed := compiled.Thaw()
+		for _, name := range names {
+			matched := expected.Match(name)
+			if filtered.Match(name) != matched || filtered.MatchBytes([]byte(name)) != matched {
+				t.Fatalf("Match(%v) with %v differs", name, more)
+			}
+			if compiled.Match(name) != matched || thawed.Match(name) != matched {
+				t.Fatalf("Match(%v) with %v differs after Freeze", name, more)
+			}
+			expectedRule, _ := expected.MatchRule(name)
+			if rule, _ := filtered.MatchRule(name); rule != expectedRule {
+				t.Fatalf("MatchRule(%v) with %v got %v expected %v", name, more, rule, expectedRule)
+			}
+			_, expectedOK := expected.Lookup(name)
+			if _, ok := filtered.Lookup(name); ok != expectedOK {
+				t.Fatalf("Lookup(%v) with %v got %v expected %v", name, more, ok, expectedOK)
+			}
+		}
+	}
+}
+
+func TestPrefilterRejects(t *testing.T) {
+	rules := realisticRules(10000)
+	root, err := MakeTrie(rules, WithPrefilter(0.01))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if root.filter == nil || root.filter.all.Load() {
+		t.Fatal("MakeTrie did not build a filter")
+	}
+	passed := 0
+	const misses = 100000
+	for i := 0; i < misses; i++ {
+		if mayMatch(root.filter, fmt.Sprintf("miss%d.example", i)) {
+			passed++
+		}
+	}
+	// Every name has two suffixes, so about twice the rate passes.
+	if rate := float64(passed) / misses; rate > 0.04 {
+		t.Errorf("got a false positive rate of %v", rate)
+	}
+	for _, rule := range rules {
+		parsed, _ := ParseRule(rule)
+		if name := "x." + strings.Join(parsed.Labels, "."); !mayMatch(root.filter, name) {
+			t.Fatalf("filter rejected %v", name)
+		}
+	}
+
+	root.Insert("+")
+	if !root.filter.all.Load() || !root.Match("miss.example") {
+		t.Error("a root wildcard did not pass every name")
+	}
+}
+
+// TestPrefilterGrowth checks that rules added after the filter is full are
+// still matched.
+func TestPrefilterGrowth(t *testing.T) {
+	root := New(WithPrefilter(0.01))
+	n := 3 * minPrefilterCapacity
+	for i := 0; i < n; i++ {
+		root.Insert(fmt.Sprintf("+.d%d.com", i))
+	}
+	if root.filter.capacity < n {
+		t.Errorf("filter did not grow, capacity %d", root.filter.capacity)
+	}
+	for i := 0; i < n; i++ {
+		if !root.Match(fmt.Sprintf("x.d%d.com", i)) {
+			t.Fatalf("Match(x.d%d.com) failed", i)
+		}
+	}
+	root.Remove("+.d0.com")
+	if root.Match("x.d0.com") {
+		t.Error("Match of a removed rule succeeded")
+	}
+
+	built, err := BuildFromReader(strings.NewReader("+.d1.com\nexample.org\n"), WithPrefilter(0.01), WithWorkers(4))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if built.filter == nil || !built.Match("x.d1.com") || !built.Match("example.org") || built.Match("x.example.org") {
+		t.Error("BuildFromReader with a prefilter got wrong matches")
+	}
+}
+
+func TestPrefilterSync(t *testing.T) {
+	s := NewSyncTrie(New(WithPrefilter(0.01)))
+	var wg sync.WaitGroup
+	done := make(chan struct{})
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for {
+			select {
+			case <-done:
+				return
+			default:
+				s.Match("x.d1.com")
+			}
+		}
+	}()
+	for i := 0; i < 2*minPrefilterCapacity; i++ {
+		if err := s.Insert(fmt.Sprintf("+.d%d.com", i)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	close(done)
+	wg.Wait()
+	if !s.Match("x.d1.com") || s.Match("x.example.com") {
+		t.Error("SyncTrie with a prefilter got wrong matches")
+	}
+}
+
+func benchmarkPrefilterMiss(b *testing.B, opts ...Option) {
+	root, err := MakeTrie(realisticRules(100000), opts...)
+	if err != nil {
+		b.Fatal(err)
+	}
+	queries := make([]string, 1024)
+	for i := range queries {
+		queries[i] = fmt.Sprintf("www.miss%d.com", i)
+	}
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		root.Match(queries[i%len(queries)])
+	}
+}
+
+func BenchmarkMissWithoutPrefilter(b *testing.B) { benchmarkPrefilterMiss(b) }
+func BenchmarkMissWithPrefilter(b *testing.B)    { benchmarkPrefilterMiss(b, WithPrefilter(0.01)) }
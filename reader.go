@@ -0,0 +1,161 @@
+package dnstrie
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+)
+
+// BuildFromReader returns the root of a trie given rules read from r, one per
+// line, configured with opts like MakeTrie. Surrounding whitespace is
+// trimmed, and blank lines and lines starting with "#" are skipped. Rules are
+// parsed as they are read and added to a subtrie per top-level label, with
+// the subtries split over several goroutines, see WithWorkers, so r is never
+// held in memory. With WithStrictValidation, every invalid rule is reported in
+// RuleErrors with its line.
+func BuildFromReader(r io.Reader, opts ...Option) (*DomainTrie, error) {
+	root := New(opts...)
+	b := newBuilder(root)
+	var errs RuleErrors
+	scanner := bufio.NewScanner(r)
+	line, index := 0, 0
+	for scanner.Scan() {
+		line++
+		rule := strings.TrimSpace(scanner.Text())
+		if rule == "" || rule[0] == '#' {
+			continue
+		}
+		reversedLabels, kind, err := root.parse(rule)
+		switch {
+		case err == nil:
+			b.add(reversedLabels, kind)
+		case root.opts.strict:
+			errs = append(errs, &RuleError{Index: index, Line: line, Rule: rule, Err: err})
+		default:
+			b.wait()
+			return nil, fmt.Errorf("Failed to build DomainTrie: line %d: Failed to add %v: %v", line, rule, err)
+		}
+		index++
+	}
+	b.wait()
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("Failed to build DomainTrie: Failed to read rules: %v", err)
+	}
+	if len(errs) > 0 {
+		return nil, errs
+	}
+	return root, nil
+}
+
+// parsedRule is a rule as passed to Trie.add.
+type parsedRule struct {
+	reversedLabels []string
+	kind           uint8
+}
+
+// builder adds rules to a trie from several goroutines. Every top-level label
+// belongs to one worker, which adds the rules below it to a trie of its own,
+// so the subtries of the workers are disjoint and are merged by moving the
+// children of their roots.
+type builder struct {
+	root  *DomainTrie
+	parts []*Trie[struct{}]
+	// pending holds the rules of each worker not yet handed to it.
+	pending [][]parsedRule
+	jobs    []chan []parsedRule
+	wg      sync.WaitGroup
+	// patterns lists the top-level pattern labels in the order they were
+	// first added, which is the order they are tried in.
+	patterns []string
+	seen     map[string]bool
+}
+
+// newBuilder returns a builder adding to root with the workers configured for
+// it. With a single worker, rules are added to root directly.
+func newBuilder(root *DomainTrie) *builder {
+	b := &builder{root: root, seen: make(map[string]bool)}
+	workers := root.opts.workerCount()
+	if workers <= 1 {
+		return b
+	}
+	b.parts = make([]*Trie[struct{}], workers)
+	b.pending = make([][]parsedRule, workers)
+	b.jobs = make([]chan []parsedRule, workers)
+	b.wg.Add(workers)
+	for w := range b.parts {
+		part, jobs := NewTrie[struct{}](), make(chan []parsedRule, 1)
+		b.parts[w], b.jobs[w] = part, jobs
+		go func() {
+			defer b.wg.Done()
+			for rules := range jobs {
+				for _, r := range rules {
+					part.add(r.reversedLabels, r.kind, struct{}{})
+				}
+			}
+		}()
+	}
+	return b
+}
+
+// add adds the rule of the given kind ending at reversedLabels.
+func (b *builder) add(reversedLabels []string, kind uint8) {
+	if b.parts == nil {
+		b.root.add(reversedLabels, kind, struct{}{})
+		return
+	}
+	tld := reversedLabels[0]
+	if isPatternChild(tld) && !b.seen[tld] {
+		b.seen[tld] = true
+		b.patterns = append(b.patterns, tld)
+	}
+	w := labelHash(tld) % uint32(len(b.parts))
+	b.pending[w] = append(b.pending[w], parsedRule{reversedLabels, kind})
+	if len(b.pending[w]) == batchSize {
+		b.jobs[w] <- b.pending[w]
+		b.pending[w] = make([]parsedRule, 0, batchSize)
+	}
+}
+
+// wait waits for the workers to add every rule and merges their subtries
+// into the root.
+func (b *builder) wait() {
+	if b.parts == nil {
+		return
+	}
+	for w, jobs := range b.jobs {
+		if len(b.pending[w]) > 0 {
+			jobs <- b.pending[w]
+		}
+		close(jobs)
+	}
+	b.wg.Wait()
+	root := &b.root.root
+	for _, part := range b.parts {
+		for label, child := range part.root.others {
+			if root.others == nil {
+				root.others = make(nodeMap[struct{}])
+			}
+			root.others[label] = child
+		}
+		b.root.exceptions += part.exceptions
+	}
+	root.patterns = b.patterns
+	// A "*" rule decides if the root matches.
+	root.settle()
+	if b.root.opts.prefilter > 0 {
+		b.root.buildFilter()
+	}
+	b.parts = nil
+}
+
+// labelHash is the FNV-1a hash of label.
+func labelHash(label string) uint32 {
+	h := uint32(2166136261)
+	for i := 0; i < len(label); i++ {
+		h ^= uint32(label[i])
+		h *= 16777619
+	}
+	return h
+}
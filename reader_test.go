@@ -0,0 +1,79 @@
+package dnstrie
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+	"testing/iotest"
+)
+
+func TestBuildFromReader(t *testing.T) {
+	input := "# ads\n+.ads.com\r\n\n  !safe.ads.com  \n# cdns\ncdn-*.net\n*.org\n*\n+2.*.io\n"
+	expected, err := MakeTrie([]string{"+.ads.com", "!safe.ads.com", "cdn-*.net", "*.org", "*", "+2.*.io"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, workers := range []int{1, 4} {
+		trie, err := BuildFromReader(strings.NewReader(input), WithWorkers(workers))
+		if err != nil {
+			t.Fatalf("BuildFromReader with %d workers failed: %v", workers, err)
+		}
+		if !reflect.DeepEqual(trie.Rules(), expected.Rules()) {
+			t.Errorf("BuildFromReader with %d workers got %v expected %v", workers, trie.Rules(), expected.Rules())
+		}
+		if trie.exceptions != expected.exceptions || trie.root.end != expected.root.end {
+			t.Errorf("BuildFromReader with %d workers got a different root", workers)
+		}
+	}
+}
+
+func TestBuildFromReaderConcurrent(t *testing.T) {
+	var rules []string
+	for i := 0; i < 5000; i++ {
+		rules = append(rules, fmt.Sprintf("host%d.zone%d.tld%d", i, i%7, i%13))
+		if i%10 == 0 {
+			rules = append(rules, fmt.Sprintf("!+.zone%d.tld%d", i%7, i%13), fmt.Sprintf("x%d*.tld%d", i%3, i%13), fmt.Sprintf("t%d?", i%5))
+		}
+	}
+	expected, err := MakeTrie(rules)
+	if err != nil {
+		t.Fatal(err)
+	}
+	trie, err := BuildFromReader(strings.NewReader(strings.Join(rules, "\n")), WithWorkers(3))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(trie.Rules(), expected.Rules()) {
+		t.Fatalf("BuildFromReader did not build the same rules as MakeTrie")
+	}
+	if !reflect.DeepEqual(trie.root.patterns, expected.root.patterns) {
+		t.Errorf("BuildFromReader got patterns %v expected %v", trie.root.patterns, expected.root.patterns)
+	}
+	if trie.exceptions != expected.exceptions {
+		t.Errorf("BuildFromReader got %d exceptions expected %d", trie.exceptions, expected.exceptions)
+	}
+}
+
+func TestBuildFromReaderErrors(t *testing.T) {
+	input := "google.com\n# comment\n[a.com\n\nbad..com\n"
+	_, err := BuildFromReader(strings.NewReader(input))
+	if err == nil || !strings.Contains(err.Error(), "line 3") {
+		t.Errorf("BuildFromReader got %v expected an error for line 3", err)
+	}
+
+	_, err = BuildFromReader(strings.NewReader(input), WithStrictValidation(), WithWorkers(2))
+	var errs RuleErrors
+	if !errors.As(err, &errs) {
+		t.Fatalf("BuildFromReader got %v expected RuleErrors", err)
+	}
+	if len(errs) != 2 || errs[0].Line != 3 || errs[0].Index != 1 || errs[1].Line != 5 || errs[1].Rule != "bad..com" {
+		t.Errorf("BuildFromReader got %v", errs)
+	}
+
+	_, err = BuildFromReader(iotest.ErrReader(errors.New("boom")))
+	if err == nil || !strings.Contains(err.Error(), "boom") {
+		t.Errorf("BuildFromReader got %v expected the read error", err)
+	}
+}
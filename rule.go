@@ -0,0 +1,160 @@
+package dnstrie
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// RuleKind is the kind of names a Rule matches relative to its zone.
+type RuleKind uint8
+
+const (
+	// Exact rules ("example.com") match only their zone.
+	Exact RuleKind = iota
+	// Subtree rules ("*.example.com") match their zone and every name
+	// below it.
+	Subtree
+	// ChildrenOnly rules ("+.example.com") match the names below their
+	// zone, optionally bounded by depth ("+2.example.com" and
+	// "=1.example.com").
+	ChildrenOnly
+)
+
+// Rule is a parsed rule. Use ParseRule to create one from its text and
+// String to turn it back into text.
+type Rule struct {
+	Kind RuleKind
+	// Exception is true for rules starting with "!", which exclude the
+	// names they match.
+	Exception bool
+	// Labels are the labels of the zone of the rule from left to right,
+	// without the leading wildcard, e.g., "www", "google" and "com".
+	Labels []string
+	// MinDepth and MaxDepth are the number of labels below the zone that
+	// a ChildrenOnly rule matches. A MaxDepth of 0 is unbounded. Either
+	// MinDepth is 1 ("+N.") or both are equal ("=N.").
+	MinDepth, MaxDepth int
+	// Line is the line of the rule in its source, or 0 if unknown. It is
+	// only used in error messages.
+	Line int
+}
+
+// ParseRule parses the text of a rule. A leading "!" makes it an exception
+// and a leading "*", "+", "+N" or "=N" label a wildcard. Every other label is
+// part of the zone and may be a glob pattern.
+func ParseRule(rule string) (Rule, error) {
+	var r Rule
+	if strings.HasPrefix(rule, "!") {
+		r.Exception = true
+		rule = rule[1:]
+	}
+	r.Labels = strings.Split(rule, ".")
+	switch first := r.Labels[0]; {
+	case first == "*":
+		r.Kind = Subtree
+	case first == "+":
+		r.Kind, r.MinDepth = ChildrenOnly, 1
+	case len(first) > 1 && (first[0] == '+' || first[0] == '=') && strings.Trim(first[1:], "0123456789") == "":
+		min, max, ok := bounds(first)
+		if !ok {
+			return Rule{}, fmt.Errorf("Failed to parse depth of %v: must be between 1 and %d", first, maxLabels)
+		}
+		r.Kind, r.MinDepth, r.MaxDepth = ChildrenOnly, min, max
+	}
+	if r.Kind != Exact {
+		r.Labels = r.Labels[1:]
+	}
+	for _, label := range r.Labels {
+		if isPattern(label) {
+			if err := checkPattern(label); err != nil {
+				return Rule{}, err
+			}
+		}
+	}
+	return r, nil
+}
+
+// String returns the text of r as accepted by ParseRule.
+func (r Rule) String() string {
+	var prefix string
+	switch {
+	case r.Kind == Subtree:
+		prefix = "*"
+	case r.Kind != ChildrenOnly:
+	case r.MaxDepth == 0:
+		prefix = "+"
+	default:
+		prefix = boundLabel(r.MinDepth, r.MaxDepth)
+	}
+	rule := joinRule(prefix, strings.Join(r.Labels, "."))
+	if r.Exception {
+		return "!" + rule
+	}
+	return rule
+}
+
+// reversed returns the labels of the path of r in the trie in reverse order,
+// with the wildcard label last, and the kind of rule ending at it.
+func (r Rule) reversed() ([]string, uint8, error) {
+	reversedLabels := make([]string, len(r.Labels), len(r.Labels)+1)
+	for i, label := range r.Labels {
+		reversedLabels[len(r.Labels)-1-i] = label
+	}
+	kind := exactRule
+	switch r.Kind {
+	case Exact:
+		// Only wildcards can be anchored at the root.
+		if len(r.Labels) == 0 {
+			return nil, 0, fmt.Errorf("Failed to use exact rule: empty zone")
+		}
+	case Subtree:
+		// A star matches both its exact parent and the normal "+"
+		// wildcard match, which is where it is stored.
+		reversedLabels = append(reversedLabels, "+")
+		kind = subtreeRule
+	case ChildrenOnly:
+		switch {
+		case r.MaxDepth == 0 && r.MinDepth <= 1:
+			reversedLabels = append(reversedLabels, "+")
+		case r.MinDepth < 1 || r.MinDepth > r.MaxDepth || r.MaxDepth > maxLabels:
+			return nil, 0, fmt.Errorf("Failed to use depths %d to %d: must be between 1 and %d", r.MinDepth, r.MaxDepth, maxLabels)
+		case r.MinDepth != 1 && r.MinDepth != r.MaxDepth:
+			return nil, 0, fmt.Errorf("Failed to use depths %d to %d: must start at 1 or be a single depth", r.MinDepth, r.MaxDepth)
+		default:
+			reversedLabels = append(reversedLabels, boundLabel(r.MinDepth, r.MaxDepth))
+		}
+		kind = childrenRule
+	default:
+		return nil, 0, fmt.Errorf("Failed to use rule kind %d", r.Kind)
+	}
+	if r.Exception {
+		kind <<= exceptionShift
+	}
+	return reversedLabels, kind, nil
+}
+
+// boundLabel returns the label of a wildcard matching min to max labels below
+// its zone, the inverse of bounds.
+func boundLabel(min, max int) string {
+	if min == max {
+		return "=" + strconv.Itoa(max)
+	}
+	return "+" + strconv.Itoa(max)
+}
+
+// MakeTrieFromRules returns the root of a trie given a slice of parsed rules.
+func MakeTrieFromRules(rules []Rule) (*DomainTrie, error) {
+	root := &DomainTrie{*NewTrie[struct{}]()}
+	for _, r := range rules {
+		reversedLabels, kind, err := r.reversed()
+		if err != nil {
+			if r.Line > 0 {
+				return nil, fmt.Errorf("Failed to build DomainTrie: line %d: %v", r.Line, err)
+			}
+			return nil, fmt.Errorf("Failed to build DomainTrie: %v", err)
+		}
+		root.add(reversedLabels, kind, struct{}{})
+	}
+	return root, nil
+}
@@ -0,0 +1,102 @@
+package dnstrie
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseRule(t *testing.T) {
+	type testCase struct {
+		rule     string
+		expected Rule
+	}
+
+	testCases := []testCase{
+		{"www.google.com", Rule{Kind: Exact, Labels: []string{"www", "google", "com"}}},
+		{"www.google.co.uk", Rule{Kind: Exact, Labels: []string{"www", "google", "co", "uk"}}},
+		{"com", Rule{Kind: Exact, Labels: []string{"com"}}},
+		{"", Rule{Kind: Exact, Labels: []string{""}}},
+		{"*.google.com", Rule{Kind: Subtree, Labels: []string{"google", "com"}}},
+		{"+.google.com", Rule{Kind: ChildrenOnly, Labels: []string{"google", "com"}, MinDepth: 1}},
+		{"foo.*.google.com", Rule{Kind: Exact, Labels: []string{"foo", "*", "google", "com"}}},
+		{"foo.+.google.com", Rule{Kind: Exact, Labels: []string{"foo", "+", "google", "com"}}},
+		{"*google.com", Rule{Kind: Exact, Labels: []string{"*google", "com"}}},
+		{"+google.com", Rule{Kind: Exact, Labels: []string{"+google", "com"}}},
+		{"+2.cdn.net", Rule{Kind: ChildrenOnly, Labels: []string{"cdn", "net"}, MinDepth: 1, MaxDepth: 2}},
+		{"=1.cdn.net", Rule{Kind: ChildrenOnly, Labels: []string{"cdn", "net"}, MinDepth: 1, MaxDepth: 1}},
+		{"=3.cdn.net", Rule{Kind: ChildrenOnly, Labels: []string{"cdn", "net"}, MinDepth: 3, MaxDepth: 3}},
+		{"!safe.ads.com", Rule{Kind: Exact, Exception: true, Labels: []string{"safe", "ads", "com"}}},
+		{"!*.ads.com", Rule{Kind: Subtree, Exception: true, Labels: []string{"ads", "com"}}},
+		{"*", Rule{Kind: Subtree, Labels: []string{}}},
+	}
+
+	for _, tc := range testCases {
+		r, err := ParseRule(tc.rule)
+		if err != nil {
+			t.Fatalf("ParseRule(%q) failed: %v", tc.rule, err)
+		}
+		if !reflect.DeepEqual(r, tc.expected) {
+			t.Fatalf("ParseRule(%q) got %+v expected %+v", tc.rule, r, tc.expected)
+		}
+	}
+
+	for _, bad := range []string{"+0.com", "=128.com", "cdn-[0-9.com"} {
+		if _, err := ParseRule(bad); err == nil {
+			t.Fatalf("ParseRule(%q) did not fail", bad)
+		}
+	}
+}
+
+func TestRuleString(t *testing.T) {
+	for _, rule := range []string{
+		"www.google.com", "*.google.com", "+.google.com", "+2.cdn.net", "=3.cdn.net",
+		"!safe.ads.com", "!+.ads.com", "api.*.corp.com", "cdn-[0-9]*.net", "*", "+", "",
+	} {
+		r, err := ParseRule(rule)
+		if err != nil {
+			t.Fatalf("ParseRule(%q) failed: %v", rule, err)
+		}
+		if actual := r.String(); actual != rule {
+			t.Fatalf("ParseRule(%q).String() got %q", rule, actual)
+		}
+	}
+	// "+1." and "=1." are the same rule.
+	if r, _ := ParseRule("+1.cdn.net"); r.String() != "=1.cdn.net" {
+		t.Fatalf("String() got %q expected =1.cdn.net", r.String())
+	}
+}
+
+func TestMakeTrieFromRules(t *testing.T) {
+	texts := []string{"*.google.com", "+.ads.example.com", "!safe.ads.example.com", "+2.cdn.net", "api.*.corp.com"}
+	var rules []Rule
+	for i, text := range texts {
+		r, err := ParseRule(text)
+		if err != nil {
+			t.Fatalf("ParseRule(%q) failed: %v", text, err)
+		}
+		r.Line = i + 1
+		rules = append(rules, r)
+	}
+	root, err := MakeTrieFromRules(rules)
+	if err != nil {
+		t.Fatalf("Failed to MakeTrieFromRules: %v", err)
+	}
+	expected, _ := MakeTrie(texts)
+	if !reflect.DeepEqual(root, expected) {
+		t.Fatalf("MakeTrieFromRules and MakeTrie built different tries")
+	}
+
+	rules = append(rules, Rule{Kind: ChildrenOnly, Labels: []string{"net"}, MinDepth: 2, MaxDepth: 3, Line: 7})
+	if _, err := MakeTrieFromRules(rules); err == nil || err.Error() != "Failed to build DomainTrie: line 7: Failed to use depths 2 to 3: must start at 1 or be a single depth" {
+		t.Fatalf("MakeTrieFromRules did not reject an invalid rule: %v", err)
+	}
+	for _, r := range []Rule{{Line: 3}, {Kind: Exact, Exception: true, Labels: []string{}, Line: 3}} {
+		if _, err := MakeTrieFromRules([]Rule{r}); err == nil || err.Error() != "Failed to build DomainTrie: line 3: Failed to use exact rule: empty zone" {
+			t.Fatalf("MakeTrieFromRules did not reject %+v: %v", r, err)
+		}
+	}
+	root, err = MakeTrieFromRules([]Rule{{Kind: Subtree}})
+	if err != nil || !root.Match("example.com") {
+		t.Fatalf("MakeTrieFromRules did not accept a root wildcard: %v", err)
+	}
+}
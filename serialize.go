@@ -0,0 +1,496 @@
+package dnstrie
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"math/bits"
+)
+
+// The binary format of a trie is a fixed size header followed by the arrays of
+// its CompiledTrie, all little endian:
+//
+//	magic       [8]byte  "DNSTRIE\x00"
+//	version     uint32
+//	flags       uint32   flagExceptions if the trie has exception rules and
+//	                     flagNormalize and flagTrailingDot for its options
+//	nodes       uint32   number of nodes
+//	labels      uint32   number of distinct labels
+//	labelBytes  uint32   total length of the distinct labels
+//	indexSize   uint32   number of slots in the child index
+//	checksum    uint32   CRC-32C of everything after the header
+//	reserved    uint32
+//
+// The header is followed by the end, except, wildcard, wildcard except and
+// pattern bitsets, the label offsets, node labels, first children, child index,
+// rule kinds and the label text. Every section starts at a multiple of 8 bytes
+// so the arrays can be used in place when the file is memory-mapped.
+//
+// Version 2 added exception rules and version 3 single label wildcards.
+const (
+	fileMagic   = "DNSTRIE\x00"
+	fileVersion = 3
+	headerSize  = 40
+
+	flagExceptions  = 1 << 0
+	flagNormalize   = 1 << 1
+	flagTrailingDot = 1 << 2
+	knownFlags      = flagExceptions | flagNormalize | flagTrailingDot
+)
+
+// Sections of the body of a trie file, in file order.
+const (
+	endSection = iota
+	exceptSection
+	wildcardSection
+	wildcardExceptSection
+	patternSection
+	labelOffsetsSection
+	nodeLabelsSection
+	firstChildSection
+	indexSection
+	rulesSection
+	labelsSection
+	numSections
+)
+
+// ErrInvalidTrie is returned, possibly wrapped, when binary trie data is
+// truncated, corrupted or was not written by this package.
+var ErrInvalidTrie = errors.New("invalid trie data")
+
+var castagnoli = crc32.MakeTable(crc32.Castagnoli)
+
+type fileHeader struct {
+	version    uint32
+	flags      uint32
+	nodes      uint32
+	labels     uint32
+	labelBytes uint32
+	indexSize  uint32
+	checksum   uint32
+}
+
+// section is the position of one array in the body of a trie file.
+type section struct {
+	offset, size uint64
+}
+
+// sections returns the position of every array described by h, in file order,
+// and the total size of the body.
+func (h *fileHeader) sections() ([numSections]section, uint64) {
+	words := (uint64(h.nodes) + 63) / 64
+	var sizes [numSections]uint64
+	sizes[endSection] = 8 * words
+	sizes[exceptSection] = 8 * words
+	sizes[wildcardSection] = 8 * words
+	sizes[wildcardExceptSection] = 8 * words
+	sizes[patternSection] = 8 * words
+	sizes[labelOffsetsSection] = 4 * (uint64(h.labels) + 1)
+	sizes[nodeLabelsSection] = 4 * uint64(h.nodes)
+	sizes[firstChildSection] = 4 * (uint64(h.nodes) + 1)
+	sizes[indexSection] = 4 * uint64(h.indexSize)
+	sizes[rulesSection] = uint64(h.nodes)
+	sizes[labelsSection] = uint64(h.labelBytes)
+
+	var s [numSections]section
+	var offset uint64
+	for i, size := range sizes {
+		s[i] = section{offset, size}
+		offset += (size + 7) &^ 7
+	}
+	return s, offset
+}
+
+func (h *fileHeader) marshal() []byte {
+	b := make([]byte, headerSize)
+	copy(b, fileMagic)
+	binary.LittleEndian.PutUint32(b[8:], h.version)
+	binary.LittleEndian.PutUint32(b[12:], h.flags)
+	binary.LittleEndian.PutUint32(b[16:], h.nodes)
+	binary.LittleEndian.PutUint32(b[20:], h.labels)
+	binary.LittleEndian.PutUint32(b[24:], h.labelBytes)
+	binary.LittleEndian.PutUint32(b[28:], h.indexSize)
+	binary.LittleEndian.PutUint32(b[32:], h.checksum)
+	return b
+}
+
+func unmarshalHeader(b []byte) (*fileHeader, error) {
+	if len(b) < headerSize {
+		return nil, fmt.Errorf("%w: truncated header", ErrInvalidTrie)
+	}
+	if string(b[:8]) != fileMagic {
+		return nil, fmt.Errorf("%w: bad magic bytes", ErrInvalidTrie)
+	}
+	h := &fileHeader{
+		version:    binary.LittleEndian.Uint32(b[8:]),
+		flags:      binary.LittleEndian.Uint32(b[12:]),
+		nodes:      binary.LittleEndian.Uint32(b[16:]),
+		labels:     binary.LittleEndian.Uint32(b[20:]),
+		labelBytes: binary.LittleEndian.Uint32(b[24:]),
+		indexSize:  binary.LittleEndian.Uint32(b[28:]),
+		checksum:   binary.LittleEndian.Uint32(b[32:]),
+	}
+	if h.version != fileVersion {
+		return nil, fmt.Errorf("%w: unsupported version %d", ErrInvalidTrie, h.version)
+	}
+	if h.flags&^knownFlags != 0 || binary.LittleEndian.Uint32(b[36:]) != 0 {
+		return nil, fmt.Errorf("%w: unknown flags", ErrInvalidTrie)
+	}
+	return h, nil
+}
+
+func (c *CompiledTrie) header() *fileHeader {
+	h := &fileHeader{
+		version:    fileVersion,
+		nodes:      uint32(len(c.nodeLabels)),
+		labels:     uint32(len(c.labelOffsets)) - 1,
+		labelBytes: uint32(len(c.labels)),
+		indexSize:  uint32(len(c.index)),
+	}
+	if c.exceptions {
+		h.flags |= flagExceptions
+	}
+	if c.opts.normalize {
+		h.flags |= flagNormalize
+	}
+	if c.opts.trailingDot {
+		h.flags |= flagTrailingDot
+	}
+	return h
+}
+
+// writeBody writes the arrays of c in file order, padding every section to a
+// multiple of 8 bytes.
+func (c *CompiledTrie) writeBody(w io.Writer) error {
+	bw := bufio.NewWriter(w)
+	var scratch [8]byte
+	var written int
+	pad := func() {
+		for ; written%8 != 0; written++ {
+			bw.WriteByte(0)
+		}
+	}
+	for _, bits := range []bitset{c.end, c.except, c.wildcard, c.wildcardExcept, c.pattern} {
+		for _, word := range bits {
+			binary.LittleEndian.PutUint64(scratch[:], word)
+			bw.Write(scratch[:])
+		}
+		written += 8 * len(bits)
+	}
+	for _, array := range [][]uint32{c.labelOffsets, c.nodeLabels, c.firstChild, c.index} {
+		for _, v := range array {
+			binary.LittleEndian.PutUint32(scratch[:], v)
+			bw.Write(scratch[:4])
+		}
+		written += 4 * len(array)
+		pad()
+	}
+	for _, array := range [][]byte{c.rules, c.labels} {
+		bw.Write(array)
+		written += len(array)
+		pad()
+	}
+	return bw.Flush()
+}
+
+// WriteTo writes c to w in the binary trie format and returns the number of
+// bytes written.
+func (c *CompiledTrie) WriteTo(w io.Writer) (int64, error) {
+	if len(c.nodeLabels) == 0 {
+		c = emptyDomainTrie.Freeze()
+	}
+	h := c.header()
+	crc := crc32.New(castagnoli)
+	if err := c.writeBody(crc); err != nil {
+		return 0, err
+	}
+	h.checksum = crc.Sum32()
+
+	cw := &countingWriter{w: w}
+	if _, err := cw.Write(h.marshal()); err != nil {
+		return cw.n, fmt.Errorf("Failed to write trie: %v", err)
+	}
+	if err := c.writeBody(cw); err != nil {
+		return cw.n, fmt.Errorf("Failed to write trie: %v", err)
+	}
+	return cw.n, nil
+}
+
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (cw *countingWriter) Write(p []byte) (int, error) {
+	n, err := cw.w.Write(p)
+	cw.n += int64(n)
+	return n, err
+}
+
+// MarshalBinary implements encoding.BinaryMarshaler using the format written
+// by WriteTo.
+func (c *CompiledTrie) MarshalBinary() ([]byte, error) {
+	var buf bytes.Buffer
+	if _, err := c.WriteTo(&buf); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// ReadFrom replaces c with a trie read from r in the binary trie format and
+// returns the number of bytes read. The data is verified against its checksum
+// and checked for consistency, and an error wrapping ErrInvalidTrie is
+// returned if it is truncated or corrupted.
+func (c *CompiledTrie) ReadFrom(r io.Reader) (int64, error) {
+	var header [headerSize]byte
+	n, err := io.ReadFull(r, header[:])
+	if err != nil {
+		return int64(n), fmt.Errorf("%w: truncated header", ErrInvalidTrie)
+	}
+	h, err := unmarshalHeader(header[:])
+	if err != nil {
+		return int64(n), err
+	}
+	_, size := h.sections()
+	// Read through a LimitReader rather than allocating size bytes up front
+	// so a corrupted size cannot exhaust memory.
+	body, err := io.ReadAll(io.LimitReader(r, int64(size)))
+	total := int64(n + len(body))
+	if err != nil {
+		return total, fmt.Errorf("Failed to read trie: %v", err)
+	}
+	decoded, err := decodeCompiled(h, body)
+	if err != nil {
+		return total, err
+	}
+	*c = *decoded
+	return total, nil
+}
+
+// UnmarshalBinary implements encoding.BinaryUnmarshaler for data produced by
+// MarshalBinary or WriteTo.
+func (c *CompiledTrie) UnmarshalBinary(data []byte) error {
+	h, err := unmarshalHeader(data)
+	if err != nil {
+		return err
+	}
+	decoded, err := decodeCompiled(h, data[headerSize:])
+	if err != nil {
+		return err
+	}
+	*c = *decoded
+	return nil
+}
+
+// decodeCompiled verifies body against h and copies it into a CompiledTrie.
+func decodeCompiled(h *fileHeader, body []byte) (*CompiledTrie, error) {
+	if err := h.checkBody(body, true); err != nil {
+		return nil, err
+	}
+	c := h.compiled(body, decodeBitset, decodeUint32s, func(b []byte) []byte {
+		return append([]byte(nil), b...)
+	})
+	if err := c.validate(); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// checkBody checks that body has the length described by h and, if checksum
+// is true, that it matches the checksum in h.
+func (h *fileHeader) checkBody(body []byte, checksum bool) error {
+	if _, size := h.sections(); uint64(len(body)) != size {
+		return fmt.Errorf("%w: expected %d bytes of data, got %d", ErrInvalidTrie, size, len(body))
+	}
+	if checksum && crc32.Checksum(body, castagnoli) != h.checksum {
+		return fmt.Errorf("%w: checksum mismatch", ErrInvalidTrie)
+	}
+	return nil
+}
+
+// compiled returns the CompiledTrie stored in body, using the given functions
+// to turn each section into an array.
+func (h *fileHeader) compiled(body []byte, bitsets func([]byte) bitset, uint32s func([]byte) []uint32, bytes func([]byte) []byte) *CompiledTrie {
+	sections, _ := h.sections()
+	data := func(i int) []byte {
+		return body[sections[i].offset : sections[i].offset+sections[i].size]
+	}
+	return &CompiledTrie{
+		end:            bitsets(data(endSection)),
+		except:         bitsets(data(exceptSection)),
+		wildcard:       bitsets(data(wildcardSection)),
+		wildcardExcept: bitsets(data(wildcardExceptSection)),
+		pattern:        bitsets(data(patternSection)),
+		labelOffsets:   uint32s(data(labelOffsetsSection)),
+		nodeLabels:     uint32s(data(nodeLabelsSection)),
+		firstChild:     uint32s(data(firstChildSection)),
+		index:          uint32s(data(indexSection)),
+		rules:          bytes(data(rulesSection)),
+		labels:         bytes(data(labelsSection)),
+		exceptions:     h.flags&flagExceptions != 0,
+		opts: options{
+			normalize:   h.flags&flagNormalize != 0,
+			trailingDot: h.flags&flagTrailingDot != 0,
+		},
+	}
+}
+
+func decodeBitset(b []byte) bitset {
+	words := make(bitset, len(b)/8)
+	for i := range words {
+		words[i] = binary.LittleEndian.Uint64(b[8*i:])
+	}
+	return words
+}
+
+func decodeUint32s(b []byte) []uint32 {
+	values := make([]uint32, len(b)/4)
+	for i := range values {
+		values[i] = binary.LittleEndian.Uint32(b[4*i:])
+	}
+	return values
+}
+
+// validate checks that every index in c is in bounds and that the nodes form
+// a tree numbered in breadth-first order, so that matching against c cannot
+// panic or loop forever.
+func (c *CompiledTrie) validate() error {
+	invalid := func(what string) error {
+		return fmt.Errorf("%w: %s", ErrInvalidTrie, what)
+	}
+	nodes := uint32(len(c.nodeLabels))
+	if nodes == 0 || len(c.labelOffsets) == 0 {
+		return invalid("no root node")
+	}
+	labels := uint32(len(c.labelOffsets)) - 1
+	if c.labelOffsets[0] != 0 || c.labelOffsets[labels] != uint32(len(c.labels)) {
+		return invalid("label offsets out of range")
+	}
+	for i := uint32(0); i < labels; i++ {
+		if c.labelOffsets[i] > c.labelOffsets[i+1] {
+			return invalid("label offsets out of order")
+		}
+	}
+	for _, id := range c.nodeLabels {
+		if id >= labels {
+			return invalid("label id out of range")
+		}
+	}
+	if c.firstChild[nodes] != nodes {
+		return invalid("children out of range")
+	}
+	for i := uint32(0); i < nodes; i++ {
+		if c.firstChild[i] <= i || c.firstChild[i] > c.firstChild[i+1] {
+			return invalid("children out of breadth-first order")
+		}
+	}
+	if c.firstChild[0] != 1 {
+		return invalid("root children out of range")
+	}
+	size := uint32(len(c.index))
+	if size&(size-1) != 0 || size < nodes {
+		return invalid("child index has a bad size")
+	}
+	empty := false
+	for _, slot := range c.index {
+		if slot > nodes {
+			return invalid("child index out of range")
+		}
+		empty = empty || slot == 0
+	}
+	if !empty {
+		// Lookups probe until they find an empty slot.
+		return invalid("child index is full")
+	}
+	for _, rules := range c.rules {
+		if rules&^(exactRule|childrenRule|subtreeRule|exceptionRules) != 0 {
+			return invalid("unknown rule kind")
+		}
+	}
+	return nil
+}
+
+// Thaw returns a DomainTrie with the same rules as c, e.g., to modify a trie
+// read with ReadFrom.
+func (c *CompiledTrie) Thaw() *DomainTrie {
+	root := New()
+	root.opts = c.opts
+	if len(c.nodeLabels) == 0 {
+		return root
+	}
+	// Build every distinct label string once so thawed nodes share them.
+	labels := make([]string, len(c.labelOffsets)-1)
+	for i := range labels {
+		labels[i] = string(c.labels[c.labelOffsets[i]:c.labelOffsets[i+1]])
+	}
+	nodes := make([]*node[struct{}], len(c.nodeLabels))
+	nodes[0] = &root.root
+	for i := range nodes {
+		n := nodes[i]
+		if i > 0 {
+			n.label = labels[c.nodeLabels[i]]
+		}
+		n.end = c.end.has(uint32(i))
+		n.except = c.except.has(uint32(i))
+		n.rules = c.rules[i]
+		root.exceptions += bits.OnesCount8(n.rules & exceptionRules)
+		first, last := c.firstChild[i], c.firstChild[i+1]
+		if first == last {
+			continue
+		}
+		n.others = make(nodeMap[struct{}], last-first)
+		for child := first; child < last; child++ {
+			nodes[child] = newNode[struct{}](labels[c.nodeLabels[child]])
+			n.others[nodes[child].label] = nodes[child]
+			if c.pattern.has(child) {
+				n.patterns = append(n.patterns, nodes[child].label)
+			}
+		}
+	}
+	for _, child := range root.root.others {
+		child.compactAll()
+	}
+	if root.opts.prefilter > 0 {
+		root.buildFilter()
+	}
+	return root
+}
+
+// WriteTo writes root to w in the binary trie format and returns the number of
+// bytes written. It is equivalent to root.Freeze().WriteTo(w).
+func (root *DomainTrie) WriteTo(w io.Writer) (int64, error) {
+	return root.Freeze().WriteTo(w)
+}
+
+// ReadFrom replaces root with a trie read from r in the binary trie format and
+// returns the number of bytes read. See CompiledTrie.ReadFrom.
+func (root *DomainTrie) ReadFrom(r io.Reader) (int64, error) {
+	var c CompiledTrie
+	n, err := c.ReadFrom(r)
+	if err != nil {
+		return n, err
+	}
+	*root = *c.Thaw()
+	return n, nil
+}
+
+// MarshalBinary implements encoding.BinaryMarshaler using the format written
+// by WriteTo.
+func (root *DomainTrie) MarshalBinary() ([]byte, error) {
+	return root.Freeze().MarshalBinary()
+}
+
+// UnmarshalBinary implements encoding.BinaryUnmarshaler for data produced by
+// MarshalBinary or WriteTo.
+func (root *DomainTrie) UnmarshalBinary(data []byte) error {
+	var c CompiledTrie
+	if err := c.UnmarshalBinary(data); err != nil {
+		return err
+	}
+	*root = *c.Thaw()
+	return nil
+}
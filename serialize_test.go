@@ -0,0 +1,146 @@
+package dnstrie
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"hash/crc32"
+	"reflect"
+	"testing"
+)
+
+func TestWriteToReadFrom(t *testing.T) {
+	rules := append([]string{"*.google.com", "google.com", "+.mail.google.com", "+.biz", "x.+.weird.net", "!safe.biz", "!*.web.google.com", "api.*.corp.example.com", "*.*.example.net", "cdn-[0-9]*.example.org", "+2.cdn.example.org", "!=1.cdn.example.org"}, benchmarkRules(1000)...)
+	root, err := MakeTrie(rules)
+	if err != nil {
+		t.Fatalf("Failed to MakeTrie: %v", err)
+	}
+	var buf bytes.Buffer
+	written, err := root.WriteTo(&buf)
+	if err != nil || written != int64(buf.Len()) {
+		t.Fatalf("WriteTo wrote %d bytes (buffer has %d): %v", written, buf.Len(), err)
+	}
+	data := buf.Bytes()
+
+	var read DomainTrie
+	n, err := read.ReadFrom(bytes.NewReader(data))
+	if err != nil || n != written {
+		t.Fatalf("ReadFrom read %d of %d bytes: %v", n, written, err)
+	}
+	if !reflect.DeepEqual(&read, root) {
+		t.Fatalf("ReadFrom did not restore the trie")
+	}
+
+	var unmarshaled DomainTrie
+	if err := unmarshaled.UnmarshalBinary(data); err != nil {
+		t.Fatalf("UnmarshalBinary failed: %v", err)
+	}
+	if !reflect.DeepEqual(&unmarshaled, root) {
+		t.Fatalf("UnmarshalBinary did not restore the trie")
+	}
+	marshaled, err := root.MarshalBinary()
+	if err != nil || !bytes.Equal(marshaled, data) {
+		t.Fatalf("MarshalBinary does not match WriteTo: %v", err)
+	}
+
+	var compiled CompiledTrie
+	if err := compiled.UnmarshalBinary(data); err != nil {
+		t.Fatalf("CompiledTrie.UnmarshalBinary failed: %v", err)
+	}
+	if !reflect.DeepEqual(&compiled, root.Freeze()) {
+		t.Fatalf("CompiledTrie.UnmarshalBinary did not restore the trie")
+	}
+	for _, d := range []string{"google.com", "www.google.com", "a.mail.google.com", "foo.biz", "biz", "nope.org"} {
+		if compiled.Match(d) != root.Match(d) {
+			t.Fatalf("Match(%v) differs after reading the trie", d)
+		}
+	}
+}
+
+func TestWriteToEmpty(t *testing.T) {
+	for _, c := range []*CompiledTrie{{}, emptyDomainTrie.Freeze()} {
+		data, err := c.MarshalBinary()
+		if err != nil {
+			t.Fatalf("MarshalBinary failed: %v", err)
+		}
+		var root DomainTrie
+		if err := root.UnmarshalBinary(data); err != nil || !root.Empty() {
+			t.Fatalf("Failed to read an empty trie: %v", err)
+		}
+	}
+}
+
+func TestReadFromRejectsCorruptData(t *testing.T) {
+	root, _ := MakeTrie([]string{"*.google.com", "www.yahoo.com", "+.biz"})
+	data, err := root.MarshalBinary()
+	if err != nil {
+		t.Fatalf("MarshalBinary failed: %v", err)
+	}
+	check := func(what string, data []byte) {
+		t.Helper()
+		var c CompiledTrie
+		if err := c.UnmarshalBinary(data); !errors.Is(err, ErrInvalidTrie) {
+			t.Fatalf("UnmarshalBinary accepted %s: %v", what, err)
+		}
+		if _, err := c.ReadFrom(bytes.NewReader(data)); !errors.Is(err, ErrInvalidTrie) {
+			t.Fatalf("ReadFrom accepted %s: %v", what, err)
+		}
+	}
+
+	for i := range data {
+		corrupted := append([]byte(nil), data...)
+		corrupted[i] ^= 0x40
+		check("a flipped byte", corrupted)
+	}
+	for i := 0; i < len(data); i++ {
+		check("truncated data", data[:i])
+	}
+	// ReadFrom stops at the end of the trie, but UnmarshalBinary is given
+	// exactly one trie.
+	var c CompiledTrie
+	if err := c.UnmarshalBinary(append(append([]byte(nil), data...), 0)); !errors.Is(err, ErrInvalidTrie) {
+		t.Fatalf("UnmarshalBinary accepted trailing data: %v", err)
+	}
+
+	// Structural damage with a valid checksum, here a child pointing back
+	// at the root.
+	corrupted := append([]byte(nil), data...)
+	h, _ := unmarshalHeader(corrupted)
+	sections, _ := h.sections()
+	body := corrupted[headerSize:]
+	binary.LittleEndian.PutUint32(body[sections[firstChildSection].offset+4:], 0)
+	binary.LittleEndian.PutUint32(corrupted[32:], crc32.Checksum(body, castagnoli))
+	check("a node that is its own ancestor", corrupted)
+}
+
+func BenchmarkReadFrom100k(b *testing.B) {
+	root, _ := MakeTrie(benchmarkRules(100000))
+	data, err := root.MarshalBinary()
+	if err != nil {
+		b.Fatal(err)
+	}
+	b.SetBytes(int64(len(data)))
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		var read DomainTrie
+		if _, err := read.ReadFrom(bytes.NewReader(data)); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkCompiledReadFrom100k(b *testing.B) {
+	root, _ := MakeTrie(benchmarkRules(100000))
+	data, err := root.MarshalBinary()
+	if err != nil {
+		b.Fatal(err)
+	}
+	b.SetBytes(int64(len(data)))
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		var read CompiledTrie
+		if _, err := read.ReadFrom(bytes.NewReader(data)); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
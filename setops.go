@@ -0,0 +1,256 @@
+package dnstrie
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// ErrUnsupportedRule is returned by the set operations for tries with rules
+// they cannot combine: glob patterns, single label wildcards in the middle of
+// a rule and rules continuing below a "+" label.
+var ErrUnsupportedRule = errors.New("unsupported rule for set operations")
+
+// depths holds if the names a given number of labels below a zone match,
+// indexed by that number. The last entry stands for every deeper name.
+type depths [maxLabels + 2]bool
+
+// operand is the state of one trie while walking the zones of two tries
+// together: its node for the zone, if any, and if the names below the zone
+// that are not below one of its children match. Inside a chain, n is the node
+// of the chain and rest holds its labels below the zone.
+type operand struct {
+	n     *node[struct{}]
+	rest  string
+	below depths
+}
+
+// labels returns the labels of the children of the zone of o.
+func (o *operand) labels() []string {
+	switch {
+	case o.n == nil:
+		return nil
+	case o.rest != "":
+		return []string{headLabel(o.rest)}
+	}
+	labels := make([]string, 0, len(o.n.others))
+	for label := range o.n.others {
+		if !isWildcard(label) {
+			labels = append(labels, label)
+		}
+	}
+	return labels
+}
+
+// child returns the operand for the child zone labeled label and if the child
+// zone itself matches.
+func (o *operand) child(label string) (operand, bool, error) {
+	c := operand{}
+	switch {
+	case o.n == nil:
+	case o.rest == "":
+		if c.n = findNode(label, o.n.others); c.n != nil {
+			c.rest = c.n.label
+		}
+	case headLabel(o.rest) == label:
+		c.n, c.rest = o.n, o.rest
+	}
+	if c.n != nil {
+		c.rest = c.rest[:len(c.rest)-len(label)]
+		c.rest = strings.TrimSuffix(c.rest, ".")
+	}
+	exact := o.below[1]
+	if c.n != nil && c.rest == "" {
+		if err := c.n.checkCombinable(); err != nil {
+			return c, false, err
+		}
+		if c.n.end || c.n.except {
+			exact = c.n.end
+		}
+	}
+	c.below = o.below.shift()
+	if c.n != nil && c.rest == "" {
+		c.below.override(c.n)
+	}
+	return c, exact, nil
+}
+
+// shift returns the depths of the names below a child zone, which are one
+// label deeper below the zone of d.
+func (d *depths) shift() depths {
+	var s depths
+	copy(s[1:], d[2:])
+	s[len(s)-1] = d[len(d)-1]
+	return s
+}
+
+// override replaces the depths decided by the wildcards of n.
+func (d *depths) override(n *node[struct{}]) {
+	wildcard := findNode("+", n.others)
+	if wildcard == nil && len(n.patterns) == 0 {
+		return
+	}
+	for labels := 1; labels < len(d); labels++ {
+		if w := wildcardAt(n, wildcard, labels); w != nil {
+			d[labels] = w.end
+		}
+	}
+}
+
+// checkCombinable returns ErrUnsupportedRule if n has children the set
+// operations cannot combine.
+func (n *node[V]) checkCombinable() error {
+	for _, pattern := range n.patterns {
+		if _, _, bounded := bounds(pattern); !bounded {
+			return fmt.Errorf("%w: pattern %v", ErrUnsupportedRule, pattern)
+		}
+	}
+	for label, child := range n.others {
+		if isWildcard(label) && len(child.others) > 0 {
+			return fmt.Errorf("%w: rule below %v", ErrUnsupportedRule, label)
+		}
+	}
+	return nil
+}
+
+// combine walks the zones of a and b together and calls emit with the rules
+// of a trie matching the names for which op is true, given if they match a and
+// b, until emit returns false. Rules are only emitted where the result differs
+// from what the rules of the enclosing zones decide. A zone's wildcards are
+// written as a "+." or "!+." rule for every depth followed by "+N." or "!+N."
+// rules where the result changes from depth N+1 to N.
+func combine(a, b *DomainTrie, op func(x, y bool) bool, emit func(reversedLabels []string, kind uint8) bool) error {
+	for _, t := range []*DomainTrie{a, b} {
+		if err := t.root.checkCombinable(); err != nil {
+			return err
+		}
+	}
+	x, y := operand{n: &a.root}, operand{n: &b.root}
+	x.below.override(x.n)
+	y.below.override(y.n)
+	var parent depths
+	_, err := combineZone(nil, &x, &y, &parent, op, emit)
+	return err
+}
+
+// combineZone is combine for the zone at path, whose enclosing zone's result
+// for the names below it is parent. It returns false if emit did.
+func combineZone(path []string, x, y *operand, parent *depths, op func(x, y bool) bool, emit func(reversedLabels []string, kind uint8) bool) (bool, error) {
+	var result depths
+	inherited := parent.shift()
+	if len(path) == 0 {
+		inherited = *parent
+	}
+	changed := false
+	for labels := 1; labels < len(result); labels++ {
+		result[labels] = op(x.below[labels], y.below[labels])
+		changed = changed || result[labels] != inherited[labels]
+	}
+	if changed {
+		wildcard := append(path[:len(path):len(path)], "+")
+		kind := childrenRule
+		last := len(result) - 1
+		if !result[last] {
+			kind = exceptChildrenRule
+		}
+		if !emit(wildcard, kind) {
+			return false, nil
+		}
+		for labels := last - 1; labels >= 1; labels-- {
+			if result[labels] == result[labels+1] {
+				continue
+			}
+			kind := childrenRule
+			if !result[labels] {
+				kind = exceptChildrenRule
+			}
+			wildcard[len(wildcard)-1] = boundLabel(1, labels)
+			if !emit(wildcard, kind) {
+				return false, nil
+			}
+		}
+	} else {
+		result = inherited
+	}
+
+	labels := map[string]bool{}
+	for _, o := range []*operand{x, y} {
+		for _, label := range o.labels() {
+			labels[label] = true
+		}
+	}
+	for label := range labels {
+		cx, inX, err := x.child(label)
+		if err != nil {
+			return false, err
+		}
+		cy, inY, err := y.child(label)
+		if err != nil {
+			return false, err
+		}
+		childPath := append(path[:len(path):len(path)], label)
+		if exact := op(inX, inY); exact != result[1] {
+			kind := exactRule
+			if !exact {
+				kind = exceptExactRule
+			}
+			if !emit(childPath, kind) {
+				return false, nil
+			}
+		}
+		if ok, err := combineZone(childPath, &cx, &cy, &result, op, emit); !ok || err != nil {
+			return ok, err
+		}
+	}
+	return true, nil
+}
+
+// combined returns a trie with the options of a built from the rules emitted
+// by combine.
+func combined(a, b *DomainTrie, op func(x, y bool) bool) (*DomainTrie, error) {
+	root := New()
+	root.opts = a.opts
+	err := combine(a, b, op, func(reversedLabels []string, kind uint8) bool {
+		root.add(reversedLabels, kind, struct{}{})
+		return true
+	})
+	if err != nil {
+		return nil, err
+	}
+	return root, nil
+}
+
+// Union returns a trie matching the names matching a or b. Exceptions and
+// wildcards are taken into account, e.g., the union of "+.example.com" and
+// "!x.example.com" with "x.example.com" matches every name below
+// example.com. The rules of the result can differ from those of a and b. It
+// returns ErrUnsupportedRule if a or b has glob patterns or single label
+// wildcards in the middle of a rule.
+func Union(a, b *DomainTrie) (*DomainTrie, error) {
+	return combined(a, b, func(x, y bool) bool { return x || y })
+}
+
+// Intersect returns a trie matching the names matching both a and b, e.g.,
+// "a.example.com" for "+.example.com" and "a.example.com". See Union.
+func Intersect(a, b *DomainTrie) (*DomainTrie, error) {
+	return combined(a, b, func(x, y bool) bool { return x && y })
+}
+
+// Difference returns a trie matching the names matching a but not b, e.g.,
+// "+.example.com" and "!x.example.com" for "+.example.com" minus
+// "x.example.com". See Union.
+func Difference(a, b *DomainTrie) (*DomainTrie, error) {
+	return combined(a, b, func(x, y bool) bool { return x && !y })
+}
+
+// Subsumes returns true if every name matching b also matches a. It returns
+// false if a or b has rules the set operations do not support, see Union.
+func Subsumes(a, b *DomainTrie) bool {
+	subsumes := true
+	err := combine(b, a, func(x, y bool) bool { return x && !y }, func(_ []string, kind uint8) bool {
+		// Only a name matching b but not a leads to a positive rule.
+		subsumes = kind&exceptionRules != 0
+		return subsumes
+	})
+	return err == nil && subsumes
+}
@@ -0,0 +1,124 @@
+package dnstrie
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+)
+
+// setNames returns names of up to four labels below the zones used by the set
+// operation tests.
+func setNames() []string {
+	names := []string{"com", "net", "example.com", "example.net"}
+	for _, zone := range []string{"example.com", "example.net", "com"} {
+		for _, a := range []string{"a", "x", "y"} {
+			names = append(names, a+"."+zone)
+			for _, b := range []string{"a", "x", "b"} {
+				names = append(names, b+"."+a+"."+zone, "z."+b+"."+a+"."+zone)
+			}
+		}
+	}
+	return names
+}
+
+func TestSetOperations(t *testing.T) {
+	type testCase struct {
+		a, b []string
+	}
+	testCases := []testCase{
+		{[]string{"+.example.com"}, []string{"a.example.com"}},
+		{[]string{"+.example.com"}, []string{"x.example.com"}},
+		{[]string{"*.example.com", "!x.example.com"}, []string{"x.example.com", "+.x.example.com"}},
+		{[]string{"+.com", "!+.example.com", "a.example.com"}, []string{"*.example.com", "!*.x.example.com"}},
+		{[]string{"+2.example.com"}, []string{"+.example.com", "!=1.example.com"}},
+		{[]string{"=2.example.com", "+.example.net"}, []string{"+3.com", "!+.a.example.com"}},
+		{[]string{"+.com", "!+.com", "x.com"}, []string{"*.x.com", "!a.x.com", "+.example.net"}},
+		{nil, []string{"+.example.com"}},
+		{nil, nil},
+	}
+	ops := []struct {
+		name string
+		op   func(a, b *DomainTrie) (*DomainTrie, error)
+		want func(x, y bool) bool
+	}{
+		{"Union", Union, func(x, y bool) bool { return x || y }},
+		{"Intersect", Intersect, func(x, y bool) bool { return x && y }},
+		{"Difference", Difference, func(x, y bool) bool { return x && !y }},
+	}
+	for _, tc := range testCases {
+		a, err := MakeTrie(tc.a)
+		if err != nil {
+			t.Fatal(err)
+		}
+		b, err := MakeTrie(tc.b)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, op := range ops {
+			result, err := op.op(a, b)
+			if err != nil {
+				t.Fatalf("%v(%v, %v) failed: %v", op.name, tc.a, tc.b, err)
+			}
+			for _, name := range setNames() {
+				expected := op.want(a.Match(name), b.Match(name))
+				if actual := result.Match(name); actual != expected {
+					t.Errorf("%v(%v, %v) with rules %v matched %v: %v, expected %v", op.name, tc.a, tc.b, result.Rules(), name, actual, expected)
+				}
+			}
+		}
+		subsumes := true
+		for _, name := range setNames() {
+			if b.Match(name) && !a.Match(name) {
+				subsumes = false
+			}
+		}
+		if actual := Subsumes(a, b); actual != subsumes {
+			t.Errorf("Subsumes(%v, %v) = %v, expected %v", tc.a, tc.b, actual, subsumes)
+		}
+	}
+}
+
+func TestSetOperationRules(t *testing.T) {
+	type testCase struct {
+		op       func(a, b *DomainTrie) (*DomainTrie, error)
+		a, b     []string
+		expected []string
+	}
+	testCases := []testCase{
+		{Intersect, []string{"+.example.com"}, []string{"a.example.com"}, []string{"a.example.com"}},
+		{Difference, []string{"+.example.com"}, []string{"x.example.com"}, []string{"+.example.com", "!x.example.com"}},
+		{Union, []string{"+.example.com"}, []string{"example.com"}, []string{"*.example.com"}},
+		{Union, []string{"a.com"}, []string{"a.com"}, []string{"a.com"}},
+		{Intersect, []string{"a.com"}, []string{"b.com"}, nil},
+	}
+	for _, tc := range testCases {
+		a, _ := MakeTrie(tc.a)
+		b, _ := MakeTrie(tc.b)
+		result, err := tc.op(a, b)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if actual := result.Rules(); !reflect.DeepEqual(actual, tc.expected) {
+			t.Errorf("%v and %v got %v expected %v", tc.a, tc.b, actual, tc.expected)
+		}
+	}
+}
+
+func TestSetOperationsUnsupported(t *testing.T) {
+	for _, rule := range []string{"cdn-*.net", "api.*.corp.com", "x.+.weird.net"} {
+		a, err := MakeTrie([]string{rule})
+		if err != nil {
+			t.Fatal(err)
+		}
+		b := New()
+		if _, err := Union(a, b); !errors.Is(err, ErrUnsupportedRule) {
+			t.Errorf("Union with %v got %v expected ErrUnsupportedRule", rule, err)
+		}
+		if _, err := Intersect(b, a); !errors.Is(err, ErrUnsupportedRule) {
+			t.Errorf("Intersect with %v got %v expected ErrUnsupportedRule", rule, err)
+		}
+		if Subsumes(a, b) {
+			t.Errorf("Subsumes with %v got true", rule)
+		}
+	}
+}
@@ -0,0 +1,117 @@
+package dnstrie
+
+import (
+	"math/bits"
+	"strings"
+	"unsafe"
+)
+
+// Stats describes the size and shape of a trie. A chain of labels compressed
+// into a single node counts as one node.
+type Stats struct {
+	// Rules is the number of rules, counting "*." rules once.
+	Rules int
+	// Nodes is the number of nodes, excluding the root.
+	Nodes int
+	// WildcardNodes is the number of "+" and depth-bounded wildcard nodes.
+	WildcardNodes int
+	// MaxDepth and MeanDepth are the greatest and mean number of labels
+	// from the root to the node of a rule, like Node.Depth.
+	MaxDepth  int
+	MeanDepth float64
+	// MaxFanout is the greatest number of children of a node and
+	// MaxFanoutZone the zone of the first such node, "" for the root.
+	MaxFanout     int
+	MaxFanoutZone string
+	// Memory is an estimate of the bytes used by the nodes, their labels
+	// and child maps. It is meant to compare tries, not to account for
+	// every allocation.
+	Memory int
+}
+
+// Stats returns the statistics of the whole trie.
+func (t *Trie[V]) Stats() Stats {
+	return t.root.stats(nil)
+}
+
+// TLDStats returns the statistics of the subtree of every top-level label of
+// the trie, keyed by that label. Depths are still counted from the root, and
+// wildcards of the root, e.g., "+", have their own entry.
+func (t *Trie[V]) TLDStats() map[string]Stats {
+	tlds := make(map[string]Stats, len(t.root.others))
+	for label, child := range t.root.others {
+		tlds[label] = child.stats([]string{child.label})
+	}
+	return tlds
+}
+
+// stats returns the statistics of n and the nodes below it, excluding n
+// itself from Nodes if it is the root. path holds the labels from the root to
+// n.
+func (n *node[V]) stats(path []string) Stats {
+	var s Stats
+	depthSum := 0
+	var visit func(n *node[V], path []string, depth int)
+	visit = func(n *node[V], path []string, depth int) {
+		if len(path) > 0 {
+			s.Nodes++
+		}
+		if isWildcard(n.label) {
+			s.WildcardNodes++
+		}
+		if rules := bits.OnesCount8(n.rules); rules > 0 {
+			s.Rules += rules
+			depthSum += rules * depth
+			if depth > s.MaxDepth {
+				s.MaxDepth = depth
+			}
+		}
+		if len(n.others) > s.MaxFanout {
+			s.MaxFanout = len(n.others)
+			zone := make([]string, len(path))
+			for i, label := range path {
+				zone[len(path)-1-i] = label
+			}
+			s.MaxFanoutZone = strings.Join(zone, ".")
+		}
+		s.Memory += n.memory()
+		for _, child := range n.others {
+			visit(child, append(path[:len(path):len(path)], child.label), depth+labelCount(child.label))
+		}
+	}
+	depth := 0
+	for _, label := range path {
+		depth += labelCount(label)
+	}
+	visit(n, path, depth)
+	if s.Rules > 0 {
+		s.MeanDepth = float64(depthSum) / float64(s.Rules)
+	}
+	return s
+}
+
+// Sizes used to estimate the memory of a node. Map buckets hold up to eight
+// entries and are grown at an average load of 6.5 entries.
+const (
+	mapHeaderSize   = 48
+	mapBucketSize   = 8
+	mapLoadFactor   = 6.5
+	stringSize      = int(unsafe.Sizeof(""))
+	pointerSize     = int(unsafe.Sizeof(uintptr(0)))
+	mapBucketHeader = mapBucketSize + pointerSize
+)
+
+// memory returns the estimated bytes used by n itself, its label and its
+// child map and patterns, but not by its children.
+func (n *node[V]) memory() int {
+	size := int(unsafe.Sizeof(*n)) + len(n.label)
+	if n.others != nil {
+		buckets := 1
+		for float64(len(n.others)) > mapLoadFactor*float64(buckets) {
+			buckets *= 2
+		}
+		size += mapHeaderSize + buckets*(mapBucketHeader+mapBucketSize*(stringSize+pointerSize))
+	}
+	size += cap(n.patterns) * stringSize
+	return size
+}
@@ -0,0 +1,50 @@
+package dnstrie
+
+import (
+	"testing"
+)
+
+func TestStats(t *testing.T) {
+	trie, err := MakeTrie([]string{"google.com", "+.ads.com", "!safe.ads.com", "*.org", "+2.cdn.net", "a.b.c.net"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := trie.Stats()
+	if s.Rules != 6 || s.Nodes != 11 || s.WildcardNodes != 3 || s.MaxDepth != 4 {
+		t.Errorf("Stats got %+v", s)
+	}
+	if mean := 17.0 / 6; s.MeanDepth != mean {
+		t.Errorf("Stats got mean depth %v expected %v", s.MeanDepth, mean)
+	}
+	if s.MaxFanout != 3 || s.MaxFanoutZone != "" {
+		t.Errorf("Stats got fan-out %v at %q expected 3 at the root", s.MaxFanout, s.MaxFanoutZone)
+	}
+	if s.Memory <= 0 {
+		t.Errorf("Stats got memory %v", s.Memory)
+	}
+
+	tlds := trie.TLDStats()
+	if len(tlds) != 3 {
+		t.Fatalf("TLDStats got %v", tlds)
+	}
+	net := tlds["net"]
+	if net.Rules != 2 || net.Nodes != 4 || net.WildcardNodes != 1 || net.MaxDepth != 4 || net.MeanDepth != 3.5 {
+		t.Errorf("TLDStats got %+v for net", net)
+	}
+	if net.MaxFanout != 2 || net.MaxFanoutZone != "net" {
+		t.Errorf("TLDStats got fan-out %v at %q expected 2 at net", net.MaxFanout, net.MaxFanoutZone)
+	}
+	rules, nodes, memory := 0, 0, trie.root.memory()
+	for _, s := range tlds {
+		rules += s.Rules
+		nodes += s.Nodes
+		memory += s.Memory
+	}
+	if rules != s.Rules || nodes != s.Nodes || memory != s.Memory {
+		t.Errorf("TLDStats do not add up to %+v", s)
+	}
+
+	if s := New().Stats(); s != (Stats{Memory: New().root.memory()}) {
+		t.Errorf("Stats of an empty trie got %+v", s)
+	}
+}
@@ -0,0 +1,167 @@
+package dnstrie
+
+import (
+	"fmt"
+	"sync"
+	"sync/atomic"
+)
+
+// SyncTrie is a DomainTrie that is safe for concurrent use. Reads never block:
+// every update builds a new version of the trie that shares all unmodified
+// nodes with the previous one and atomically swaps it in, so readers always see
+// a consistent snapshot. Updates are serialized. The zero value is an empty
+// trie.
+//
+// Insert and Remove copy the nodes along the path of the rule they change,
+// including the children of each of those nodes, so changing a rule under a
+// zone with a very large fan-out (e.g., a TLD) costs time proportional to that
+// fan-out. Update copies the whole trie instead, so it costs time and memory
+// proportional to the size of the trie however few rules fn changes: use it
+// for changes that readers must see together, not to speed up many changes to
+// a large trie. To apply many changes to a large trie, e.g., a new version of a
+// blocklist, build a new trie and Swap it in.
+type SyncTrie struct {
+	mu      sync.Mutex
+	current atomic.Pointer[DomainTrie]
+}
+
+var emptyDomainTrie = &DomainTrie{*NewTrie[struct{}]()}
+
+// NewSyncTrie returns a SyncTrie serving root. root must not be modified after
+// this call.
+func NewSyncTrie(root *DomainTrie) *SyncTrie {
+	s := &SyncTrie{}
+	s.current.Store(root)
+	return s
+}
+
+// Load returns the current version of the trie. It must not be modified.
+func (s *SyncTrie) Load() *DomainTrie {
+	if root := s.current.Load(); root != nil {
+		return root
+	}
+	return emptyDomainTrie
+}
+
+// Match is like DomainTrie.Match on the current version of the trie.
+func (s *SyncTrie) Match(domain string) bool {
+	return s.Load().Match(domain)
+}
+
+// MatchBytes is like DomainTrie.MatchBytes on the current version of the trie.
+func (s *SyncTrie) MatchBytes(domain []byte) bool {
+	return s.Load().MatchBytes(domain)
+}
+
+// MatchRule is like DomainTrie.MatchRule on the current version of the trie.
+func (s *SyncTrie) MatchRule(domain string) (string, bool) {
+	return s.Load().MatchRule(domain)
+}
+
+// Insert adds a rule to the trie.
+func (s *SyncTrie) Insert(rule string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	root, err := s.Load().copyPath(rule)
+	if err != nil {
+		return err
+	}
+	if err := root.Insert(rule); err != nil {
+		return err
+	}
+	s.current.Store(root)
+	return nil
+}
+
+// Remove removes a rule from the trie and returns true, or false if the rule
+// is not present.
+func (s *SyncTrie) Remove(rule string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	root, err := s.Load().copyPath(rule)
+	if err != nil || !root.Remove(rule) {
+		return false
+	}
+	s.current.Store(root)
+	return true
+}
+
+// Swap replaces the whole trie with root, e.g., after reloading a list, and
+// returns the previous version. root must not be modified after this call.
+func (s *SyncTrie) Swap(root *DomainTrie) *DomainTrie {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	old := s.Load()
+	s.current.Store(root)
+	return old
+}
+
+// Update calls fn with a private copy of the current trie and swaps the copy
+// in if fn returns nil. Readers see either none or all of fn's changes. The
+// copy is of the whole trie, see SyncTrie.
+func (s *SyncTrie) Update(fn func(root *DomainTrie) error) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	root := &DomainTrie{s.Load().Trie}
+	root.root = *root.root.clone()
+	if err := fn(root); err != nil {
+		return err
+	}
+	s.current.Store(root)
+	return nil
+}
+
+// copyPath returns a shallow copy of root in which the nodes along the path of
+// rule, as far as they exist, and their children maps are copied, so the rule
+// can be added or removed without modifying root.
+func (root *DomainTrie) copyPath(rule string) (*DomainTrie, error) {
+	reversedLabels, _, err := root.parse(rule)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to copy path of %v: %v", rule, err)
+	}
+
+	c := &DomainTrie{root.Trie}
+	curr := &c.root
+	for i := 0; i < len(reversedLabels); {
+		curr.others = curr.others.copy()
+		curr.patterns = append([]string(nil), curr.patterns...)
+		next := findNode(reversedLabels[i], curr.others)
+		if next == nil {
+			break
+		}
+		copied := *next
+		curr.others[reversedLabels[i]] = &copied
+		curr = &copied
+		labels := copied.commonLabels(reversedLabels[i:])
+		if labels < labelCount(copied.label) {
+			// The copied chain is split in place.
+			break
+		}
+		i += labels
+	}
+	return c, nil
+}
+
+func (others nodeMap[V]) copy() nodeMap[V] {
+	if others == nil {
+		return nil
+	}
+	c := make(nodeMap[V], len(others))
+	for label, child := range others {
+		c[label] = child
+	}
+	return c
+}
+
+// clone returns a deep copy of the subtree rooted at n.
+func (n *node[V]) clone() *node[V] {
+	c := *n
+	c.patterns = append([]string(nil), n.patterns...)
+	if n.others != nil {
+		c.others = make(nodeMap[V], len(n.others))
+		for label, child := range n.others {
+			c.others[label] = child.clone()
+		}
+	}
+	return &c
+}
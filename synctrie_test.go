@@ -0,0 +1,163 @@
+package dnstrie
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+)
+
+func TestSyncTrie(t *testing.T) {
+	var s SyncTrie
+	if s.Match("google.com") || !s.Load().Empty() {
+		t.Fatalf("Zero SyncTrie is not empty")
+	}
+	if err := s.Insert("*.google.com"); err != nil {
+		t.Fatalf("Failed to Insert: %v", err)
+	}
+	before := s.Load()
+	if err := s.Insert("www.yahoo.com"); err != nil {
+		t.Fatalf("Failed to Insert: %v", err)
+	}
+	if !s.Match("google.com") || !s.Match("maps.google.com") || !s.Match("www.yahoo.com") {
+		t.Fatalf("Inserted rules do not match: %+v", s.Load())
+	}
+	if before.Match("www.yahoo.com") {
+		t.Fatalf("Insert modified an earlier version of the trie")
+	}
+
+	before = s.Load()
+	if !s.Remove("*.google.com") || s.Remove("*.google.com") {
+		t.Fatalf("Remove did not report removing *.google.com once")
+	}
+	if s.Match("google.com") || !before.Match("maps.google.com") {
+		t.Fatalf("Remove did not leave earlier versions of the trie intact")
+	}
+
+	s.Insert("api.*.corp.com")
+	before = s.Load()
+	s.Insert("www.*.corp.com")
+	if !s.Match("www.x.corp.com") || before.Match("www.x.corp.com") || !before.Match("api.x.corp.com") {
+		t.Fatalf("Insert of an interior wildcard modified an earlier version of the trie")
+	}
+	s.Remove("api.*.corp.com")
+	if s.Match("api.x.corp.com") || !before.Match("api.x.corp.com") {
+		t.Fatalf("Remove of an interior wildcard modified an earlier version of the trie")
+	}
+	s.Remove("www.*.corp.com")
+
+	err := s.Update(func(root *DomainTrie) error {
+		root.Insert("+.org")
+		root.Remove("www.yahoo.com")
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("Update failed: %v", err)
+	}
+	if !s.Match("eff.org") || s.Match("www.yahoo.com") {
+		t.Fatalf("Update was not applied: %+v", s.Load())
+	}
+	err = s.Update(func(root *DomainTrie) error {
+		root.Insert("+.net")
+		return fmt.Errorf("abort")
+	})
+	if err == nil || s.Match("foo.net") {
+		t.Fatalf("Failed Update was applied: %+v", s.Load())
+	}
+
+	root, _ := MakeTrie([]string{"example.com"})
+	if old := s.Swap(root); !old.Match("eff.org") {
+		t.Fatalf("Swap did not return the previous trie")
+	}
+	if !s.Match("example.com") || s.Match("eff.org") {
+		t.Fatalf("Swap did not replace the trie")
+	}
+}
+
+func TestSyncTrieKeepsExceptions(t *testing.T) {
+	root, _ := MakeTrie([]string{"+.ads.com", "!safe.ads.com", "old.com"})
+	s := NewSyncTrie(root)
+	s.Insert("x.com")
+	if s.Match("safe.ads.com") {
+		t.Fatalf("Insert lost the exceptions of the trie")
+	}
+	s.Remove("old.com")
+	if s.Match("safe.ads.com") {
+		t.Fatalf("Remove lost the exceptions of the trie")
+	}
+	s.Update(func(root *DomainTrie) error {
+		return root.Insert("y.com")
+	})
+	if s.Match("safe.ads.com") {
+		t.Fatalf("Update lost the exceptions of the trie")
+	}
+	if !s.Match("www.ads.com") {
+		t.Fatalf("SyncTrie lost the wildcard of the trie")
+	}
+}
+
+// TestSyncTrieConcurrent is meant to be run with -race. Readers check that
+// rules which are never removed keep matching and that rules added together
+// by Update are seen together.
+func TestSyncTrieConcurrent(t *testing.T) {
+	stable := []string{"+.google.com", "www.yahoo.com", "*.example.org"}
+	root, err := MakeTrie(stable)
+	if err != nil {
+		t.Fatalf("Failed to MakeTrie: %v", err)
+	}
+	s := NewSyncTrie(root)
+
+	const writes = 500
+	done := make(chan struct{})
+	var wg sync.WaitGroup
+	errs := make(chan error, 8)
+	for r := 0; r < 8; r++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				select {
+				case <-done:
+					return
+				default:
+				}
+				if !s.Match("mail.google.com") || !s.Match("www.yahoo.com") || !s.Match("example.org") {
+					errs <- fmt.Errorf("stable rule stopped matching")
+					return
+				}
+				snapshot := s.Load()
+				for i := 0; i < writes; i += 50 {
+					if snapshot.Match(fmt.Sprintf("a%d.com", i)) != snapshot.Match(fmt.Sprintf("b%d.net", i)) {
+						errs <- fmt.Errorf("saw half of an Update for %d", i)
+						return
+					}
+				}
+			}
+		}()
+	}
+
+	for i := 0; i < writes; i++ {
+		domain := fmt.Sprintf("x%d.google.com", i)
+		if err := s.Insert(domain); err != nil {
+			t.Fatalf("Failed to Insert: %v", err)
+		}
+		s.Update(func(root *DomainTrie) error {
+			root.Insert(fmt.Sprintf("a%d.com", i))
+			root.Insert(fmt.Sprintf("b%d.net", i))
+			return nil
+		})
+		if i%2 == 0 {
+			s.Remove(domain)
+			s.Update(func(root *DomainTrie) error {
+				root.Remove(fmt.Sprintf("a%d.com", i))
+				root.Remove(fmt.Sprintf("b%d.net", i))
+				return nil
+			})
+		}
+	}
+	close(done)
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Fatal(err)
+	}
+}
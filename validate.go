@@ -0,0 +1,94 @@
+package dnstrie
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Limits of names from RFC 1035.
+const (
+	maxLabelLength = 63
+	maxNameLength  = 253
+)
+
+// RuleError is a rule rejected by strict validation.
+type RuleError struct {
+	// Index is the position of the rule in its input.
+	Index int
+	// Line is the line of the rule in its source, or 0 if unknown.
+	Line int
+	Rule string
+	Err  error
+}
+
+func (e *RuleError) Error() string {
+	if e.Line > 0 {
+		return fmt.Sprintf("rule %d (line %d) %q: %v", e.Index, e.Line, e.Rule, e.Err)
+	}
+	return fmt.Sprintf("rule %d %q: %v", e.Index, e.Rule, e.Err)
+}
+
+func (e *RuleError) Unwrap() error {
+	return e.Err
+}
+
+// RuleErrors lists every rule rejected by strict validation, in input order.
+type RuleErrors []*RuleError
+
+func (e RuleErrors) Error() string {
+	msgs := make([]string, len(e))
+	for i, err := range e {
+		msgs[i] = err.Error()
+	}
+	return fmt.Sprintf("Failed to build DomainTrie: %d invalid rules: %s", len(e), strings.Join(msgs, "; "))
+}
+
+// Validate returns an error if r is not a well-formed rule: every label of its
+// zone must be 1 to 63 characters of letters, digits, "-" and "_" (plus the
+// glob characters in patterns), the zone must be at most 253 characters and
+// only empty for wildcards, and the depths of a ChildrenOnly rule must be
+// valid. Unicode labels must be converted to punycode first, e.g., with
+// dns.Normalize.
+func (r Rule) Validate() error {
+	// reversed rejects exact rules with an empty zone.
+	if _, _, err := r.reversed(); err != nil {
+		return err
+	}
+	length := len(r.Labels) - 1
+	for _, label := range r.Labels {
+		if err := validateLabel(label); err != nil {
+			return err
+		}
+		length += len(label)
+	}
+	if length > maxNameLength {
+		return fmt.Errorf("name is longer than %d characters", maxNameLength)
+	}
+	return nil
+}
+
+// validateLabel returns an error if label is not a valid label of a rule.
+func validateLabel(label string) error {
+	switch {
+	case label == "":
+		return fmt.Errorf("empty label")
+	case len(label) > maxLabelLength:
+		return fmt.Errorf("label %v is longer than %d characters", label, maxLabelLength)
+	}
+	pattern := isPattern(label)
+	for i := 0; i < len(label); i++ {
+		c := label[i]
+		switch {
+		case 'a' <= c && c <= 'z', 'A' <= c && c <= 'Z', '0' <= c && c <= '9', c == '-', c == '_':
+		case pattern && strings.IndexByte("*?[]!", c) >= 0:
+		case c >= 0x80:
+			return fmt.Errorf("label %q is not ASCII, use punycode", label)
+		default:
+			return fmt.Errorf("label %q has invalid character %q", label, c)
+		}
+	}
+	if pattern {
+		return checkPattern(label)
+	}
+	return nil
+}
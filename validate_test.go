@@ -0,0 +1,85 @@
+package dnstrie
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestValidate(t *testing.T) {
+	valid := []string{
+		"google.com", "*.google.com", "+.google.com", "!safe.ads.com", "_dmarc.example.com",
+		"xn--chqu66a.xn--fiqs8s", "cdn-[0-9]*.example.net", "x????.bad.biz", "api.*.corp.com", "+2.cdn.net",
+		strings.Repeat("a", 63) + ".com",
+	}
+	for _, rule := range valid {
+		r, err := ParseRule(rule)
+		if err != nil {
+			t.Fatalf("ParseRule(%q) failed: %v", rule, err)
+		}
+		if err := r.Validate(); err != nil {
+			t.Fatalf("Validate(%q) failed: %v", rule, err)
+		}
+	}
+
+	invalid := map[string]string{
+		"":                                "empty label",
+		"a..b":                            "empty label",
+		".google.com":                     "empty label",
+		"google.com.":                     "empty label",
+		" google.com":                     "invalid character",
+		"goo gle.com":                     "invalid character",
+		"万岁.中国":                           "not ASCII",
+		strings.Repeat("a", 64) + ".com":  "longer than 63",
+		strings.Repeat("a.", 127) + "com": "longer than 253",
+		"foo!.com":                        "invalid character",
+	}
+	for rule, expected := range invalid {
+		r, err := ParseRule(rule)
+		if err != nil {
+			t.Fatalf("ParseRule(%q) failed: %v", rule, err)
+		}
+		if err := r.Validate(); err == nil || !strings.Contains(err.Error(), expected) {
+			t.Fatalf("Validate(%q) got %v expected %q", rule, err, expected)
+		}
+	}
+
+	for _, tc := range []struct {
+		rule     Rule
+		expected string
+	}{
+		{Rule{}, "empty zone"},
+		{Rule{Exception: true, Labels: []string{}}, "empty zone"},
+		{Rule{Kind: ChildrenOnly, MinDepth: 2, MaxDepth: 3}, "must start at 1"},
+		{Rule{Kind: Subtree}, ""},
+		{Rule{Kind: ChildrenOnly, MinDepth: 1}, ""},
+	} {
+		err := tc.rule.Validate()
+		if tc.expected == "" && err != nil || tc.expected != "" && (err == nil || !strings.Contains(err.Error(), tc.expected)) {
+			t.Fatalf("Validate(%+v) got %v expected %q", tc.rule, err, tc.expected)
+		}
+	}
+}
+
+func TestMakeTrieStrictValidation(t *testing.T) {
+	root, err := MakeTrie([]string{"google.com", "*.yahoo.com"}, WithStrictValidation())
+	if err != nil || !root.Match("www.yahoo.com") {
+		t.Fatalf("MakeTrie failed: %v", err)
+	}
+
+	_, err = MakeTrie([]string{"google.com", "a..b", "ok.org", "+0.net", " x.com"}, WithStrictValidation())
+	var errs RuleErrors
+	if !errors.As(err, &errs) {
+		t.Fatalf("MakeTrie did not return RuleErrors: %v", err)
+	}
+	var indexes []int
+	for _, e := range errs {
+		indexes = append(indexes, e.Index)
+	}
+	if len(indexes) != 3 || indexes[0] != 1 || indexes[1] != 3 || indexes[2] != 4 {
+		t.Fatalf("MakeTrie reported rules %v expected [1 3 4]: %v", indexes, err)
+	}
+	if !strings.Contains(err.Error(), `rule 1 "a..b": empty label`) {
+		t.Fatalf("Unexpected error message: %v", err)
+	}
+}
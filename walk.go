@@ -0,0 +1,200 @@
+package dnstrie
+
+import (
+	"sort"
+	"strings"
+)
+
+// Node is a read-only view of the node of a trie that a rule ends at.
+type Node[V any] struct {
+	n     *node[V]
+	depth int
+}
+
+// Label returns the label of the node, "+" for the wildcard of "+." and "*."
+// rules and the bound for depth-bounded wildcards.
+func (n Node[V]) Label() string {
+	return firstLabel(n.n.label)
+}
+
+// Depth returns the number of labels from the root to the node, including
+// its own.
+func (n Node[V]) Depth() int {
+	return n.depth
+}
+
+// Value returns the value of the positive rule ending at the node.
+func (n Node[V]) Value() V {
+	return n.n.value
+}
+
+// Wildcard returns true if the node holds the rules for the names below its
+// parent.
+func (n Node[V]) Wildcard() bool {
+	return isWildcard(n.n.label)
+}
+
+// isWildcard returns true for the labels of nodes holding the rules for the
+// names below their parent.
+func isWildcard(label string) bool {
+	_, _, bounded := bounds(label)
+	return bounded || label == "+"
+}
+
+// Walk calls fn for every rule in the trie, ordered by the labels of their
+// zones from the root, with patterns in the order they are tried, until fn
+// returns false. Rules are passed as written, e.g., "google.com" and
+// "+.google.com" rather than "*.google.com", with the node they end at.
+func (t *Trie[V]) Walk(fn func(rule string, n Node[V]) bool) {
+	t.root.enumerate(nil, 0, t.root.rules, false, func(d decision[V], depth int) bool {
+		return fn(d.String(), Node[V]{d.node, depth})
+	})
+}
+
+// Rules returns every rule in the trie in canonical form, ordered like Walk.
+// An exact rule and a "+." rule for the same zone are returned as a single
+// "*." rule, and an exact rule covered by a "*." rule is dropped, unless an
+// exception on the same zone makes them differ.
+func (t *Trie[V]) Rules() []string {
+	var rules []string
+	own, _ := t.root.canonicalRules()
+	t.root.enumerate(nil, 0, own, true, func(d decision[V], _ int) bool {
+		rules = append(rules, d.String())
+		return true
+	})
+	return rules
+}
+
+// String returns the trie as an ASCII tree like the one in the package
+// documentation. "*." rules are shown as a "*" child and exceptions with a
+// leading "!".
+func (t *Trie[V]) String() string {
+	var b strings.Builder
+	b.WriteString("tree")
+	var render func(n *node[V], depth int)
+	render = func(n *node[V], depth int) {
+		for _, child := range n.expandedChildren() {
+			b.WriteString("\n")
+			b.WriteString(strings.Repeat("    ", depth))
+			b.WriteString("+-- ")
+			b.WriteString(child.display())
+			render(child, depth+1)
+		}
+	}
+	render(&t.root, 0)
+	return b.String()
+}
+
+// display returns the label of n as shown by String.
+func (n *node[V]) display() string {
+	label := n.label
+	if label == "+" && n.rules&(subtreeRule|exceptSubtreeRule) != 0 {
+		label = "*"
+	}
+	if n.rules&exceptionRules != 0 && n.rules&^exceptionRules == 0 {
+		label = "!" + label
+	}
+	return label
+}
+
+// sortedChildren returns the children of n, starting with its patterns in the
+// order they are tried followed by the other children sorted by label, the
+// head label for chains. Keeping the order of the patterns lets the rules be
+// added again with the same precedence.
+func (n *node[V]) sortedChildren() []*node[V] {
+	children := make([]*node[V], 0, len(n.others))
+	for _, pattern := range n.patterns {
+		children = append(children, n.others[pattern])
+	}
+	literals := len(children)
+	for _, child := range n.others {
+		if !isPatternChild(child.label) {
+			children = append(children, child)
+		}
+	}
+	sort.Slice(children[literals:], func(i, j int) bool {
+		return headLabel(children[literals+i].label) < headLabel(children[literals+j].label)
+	})
+	return children
+}
+
+// String returns the text of the rule with a leading "!" for exceptions.
+func (d *decision[V]) String() string {
+	if d.kind&exceptionRules != 0 {
+		return "!" + d.rule()
+	}
+	return d.rule()
+}
+
+// enumerate calls visit for rules, the rules ending at n, and every rule
+// below n until visit returns false, and returns false if it did. path holds
+// the labels from the root to n. If canonical is true, the rules of the
+// children are merged as described by canonicalRules.
+func (n *node[V]) enumerate(path []string, depth int, rules uint8, canonical bool, visit func(d decision[V], depth int) bool) bool {
+	if !n.visitRules(path, depth, rules, visit) {
+		return false
+	}
+	for _, child := range n.sortedChildren() {
+		rules := child.rules
+		switch {
+		case !canonical:
+		case child.label == "+":
+			_, rules = n.canonicalRules()
+		default:
+			rules, _ = child.canonicalRules()
+		}
+		if !child.enumerate(append(path[:len(path):len(path)], child.label), depth+labelCount(child.label), rules, canonical, visit) {
+			return false
+		}
+	}
+	return true
+}
+
+// visitRules is like enumerate for the rules ending at n only.
+func (n *node[V]) visitRules(path []string, depth int, rules uint8, visit func(d decision[V], depth int) bool) bool {
+	zone := make([]string, len(path))
+	for i, label := range path {
+		zone[len(path)-1-i] = label
+	}
+	for _, kind := range []uint8{exactRule, exceptExactRule, childrenRule, subtreeRule, exceptChildrenRule, exceptSubtreeRule} {
+		if rules&kind == 0 {
+			continue
+		}
+		labels := zone
+		if kind&(exactRule|exceptExactRule) == 0 {
+			// Wildcard rules are anchored at the parent.
+			labels = zone[1:]
+		}
+		if !visit(decision[V]{node: n, kind: kind, labels: labels}, depth) {
+			return false
+		}
+	}
+	return true
+}
+
+// canonicalRules returns the rules of n and of its "+" child as enumerated by
+// Rules. An exact rule is merged into the "+." or "*." rule of the same sign
+// as a "*." rule unless that changes what matches the zone itself: a "!*."
+// rule loses to an exact rule but beats a "*." rule. A "+." rule is dropped
+// next to a "*." rule of the same sign.
+func (n *node[V]) canonicalRules() (own, star uint8) {
+	own = n.rules
+	if wildcard := findNode("+", n.others); wildcard != nil {
+		star = wildcard.rules
+	}
+	if own&exactRule != 0 && star&(childrenRule|subtreeRule) != 0 && star&exceptSubtreeRule == 0 {
+		own &^= exactRule
+		star |= subtreeRule
+	}
+	if own&exceptExactRule != 0 && star&(exceptChildrenRule|exceptSubtreeRule) != 0 && own&exactRule == 0 {
+		own &^= exceptExactRule
+		star |= exceptSubtreeRule
+	}
+	if star&subtreeRule != 0 {
+		star &^= childrenRule
+	}
+	if star&exceptSubtreeRule != 0 {
+		star &^= exceptChildrenRule
+	}
+	return own, star
+}
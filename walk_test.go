@@ -0,0 +1,127 @@
+package dnstrie
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestWalk(t *testing.T) {
+	trie := NewTrie[int]()
+	for i, rule := range []string{"google.com", "+.google.com", "!safe.ads.com", "+.ads.com", "+2.cdn.net", "*.org"} {
+		trie.Add(rule, i)
+	}
+	type visited struct {
+		rule  string
+		label string
+		depth int
+		value int
+	}
+	var actual []visited
+	trie.Walk(func(rule string, n Node[int]) bool {
+		actual = append(actual, visited{rule, n.Label(), n.Depth(), n.Value()})
+		return true
+	})
+	expected := []visited{
+		{"+.ads.com", "+", 3, 3},
+		{"!safe.ads.com", "safe", 3, 0},
+		{"google.com", "google", 2, 0},
+		{"+.google.com", "+", 3, 1},
+		{"+2.cdn.net", "+2", 3, 4},
+		{"*.org", "+", 2, 5},
+	}
+	if !reflect.DeepEqual(actual, expected) {
+		t.Fatalf("Walk got %+v expected %+v", actual, expected)
+	}
+
+	count := 0
+	trie.Walk(func(string, Node[int]) bool {
+		count++
+		return count < 2
+	})
+	if count != 2 {
+		t.Fatalf("Walk did not stop when fn returned false")
+	}
+}
+
+func TestRules(t *testing.T) {
+	type testCase struct {
+		rules    []string
+		expected []string
+	}
+	testCases := []testCase{
+		{[]string{"*.google.com"}, []string{"*.google.com"}},
+		{[]string{"google.com", "+.google.com"}, []string{"*.google.com"}},
+		{[]string{"google.com", "*.google.com", "+.google.com"}, []string{"*.google.com"}},
+		{[]string{"!ads.com", "!+.ads.com"}, []string{"!*.ads.com"}},
+		{[]string{"ads.com", "+.ads.com", "!*.ads.com"}, []string{"ads.com", "+.ads.com", "!*.ads.com"}},
+		{[]string{"ads.com", "!ads.com", "!+.ads.com"}, []string{"ads.com", "!ads.com", "!+.ads.com"}},
+		{[]string{"!google.com", "+.google.com"}, []string{"!google.com", "+.google.com"}},
+		{[]string{"a.b.c", "=1.c", "x.+.weird.net", "api.*.corp.com", "cdn-*.net"}, []string{"=1.c", "a.b.c", "api.*.corp.com", "cdn-*.net", "x.+.weird.net"}},
+		{nil, nil},
+	}
+	for _, tc := range testCases {
+		root, err := MakeTrie(tc.rules)
+		if err != nil {
+			t.Fatalf("Failed to MakeTrie: %v", err)
+		}
+		actual := root.Rules()
+		if !reflect.DeepEqual(actual, tc.expected) {
+			t.Fatalf("Rules() of %q got %q expected %q", tc.rules, actual, tc.expected)
+		}
+		// The canonical rules build a trie matching the same names.
+		rebuilt, err := MakeTrie(actual)
+		if err != nil {
+			t.Fatalf("Failed to MakeTrie from Rules(): %v", err)
+		}
+		for _, d := range []string{"google.com", "www.google.com", "ads.com", "x.ads.com", "a.b.c", "x.c", "y.x.c", "api.x.corp.com"} {
+			if rebuilt.Match(d) != root.Match(d) {
+				t.Fatalf("Rules() of %q changed Match(%v)", tc.rules, d)
+			}
+		}
+	}
+}
+
+// TestRulesPatternOrder checks that Rules keeps overlapping patterns in the
+// order they are tried, so the rebuilt trie matches the same names.
+func TestRulesPatternOrder(t *testing.T) {
+	for _, rules := range [][]string{
+		{"!cdn-1*.example.net", "cdn-*.example.net"},
+		{"cdn-*.example.net", "!cdn-1*.example.net"},
+		{"!=1.example.net", "cdn-*.example.net", "+.example.net"},
+	} {
+		root, err := MakeTrie(rules)
+		if err != nil {
+			t.Fatalf("Failed to MakeTrie: %v", err)
+		}
+		actual := root.Rules()
+		if !reflect.DeepEqual(actual, rules) {
+			t.Errorf("Rules() of %q got %q", rules, actual)
+		}
+		rebuilt, err := MakeTrie(actual)
+		if err != nil {
+			t.Fatalf("Failed to MakeTrie from Rules(): %v", err)
+		}
+		for _, d := range []string{"cdn-12.example.net", "cdn-2.example.net", "x.example.net", "a.x.example.net"} {
+			if rebuilt.Match(d) != root.Match(d) {
+				t.Errorf("Rules() of %q changed Match(%v)", rules, d)
+			}
+		}
+	}
+}
+
+func TestString(t *testing.T) {
+	root, _ := MakeTrie([]string{"*.org", "google.com", "+.mail.google.com", "*.web.google.com", "!safe.google.com"})
+	expected := `tree
++-- com
+    +-- google
+        +-- mail
+            +-- +
+        +-- !safe
+        +-- web
+            +-- *
++-- org
+    +-- *`
+	if actual := root.String(); actual != expected {
+		t.Fatalf("String() got\n%v\nexpected\n%v", actual, expected)
+	}
+}